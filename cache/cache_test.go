@@ -0,0 +1,283 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/cache"
+	"github.com/zemiret/omnikanji/cache/redistest"
+)
+
+func TestLRU(t *testing.T) {
+	lru := cache.NewLRU(2)
+	lru.Set(cache.Entry{Key: "a", Value: []byte(`1`)})
+	lru.Set(cache.Entry{Key: "b", Value: []byte(`2`)})
+	_, ok := lru.Get("a") // b becomes the least recently used
+	require.True(t, ok)
+	lru.Set(cache.Entry{Key: "c", Value: []byte(`3`)})
+
+	_, ok = lru.Get("b")
+	require.False(t, ok)
+	require.ElementsMatch(t, []string{"a", "c"}, keys(lru.Entries()))
+
+	require.Equal(t, 1, lru.Purge("c"))
+	require.Equal(t, []string{"a"}, keys(lru.Entries()))
+
+	lru.Set(cache.Entry{Key: "expired", Expires: time.Now().Add(-time.Second)})
+	_, ok = lru.Get("expired")
+	require.False(t, ok)
+	require.Equal(t, []string{"a"}, keys(lru.Entries()))
+}
+
+func TestTieredDisk(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := cache.NewDisk(dir)
+	require.NoError(t, err)
+	lru := cache.NewLRU(10)
+	store := cache.NewTiered(lru, disk)
+
+	store.Set(cache.Entry{Key: "jisho:v1:何:1", Value: []byte(`{"Page":1}`)})
+	store.Set(cache.Entry{Key: "kanjidmg:v1:何", Value: []byte(`{}`)})
+
+	// a fresh process only has the disk
+	reopened, err := cache.NewDisk(dir)
+	require.NoError(t, err)
+	fresh := cache.NewLRU(10)
+	entry, ok := cache.NewTiered(fresh, reopened).Get("jisho:v1:何:1")
+	require.True(t, ok)
+	require.JSONEq(t, `{"Page":1}`, string(entry.Value))
+	_, ok = fresh.Get("jisho:v1:何:1")
+	require.True(t, ok, "disk hits are copied to memory")
+
+	require.Equal(t, 1, store.Purge("jisho:"))
+	_, ok = reopened.Get("jisho:v1:何:1")
+	require.False(t, ok)
+	require.Equal(t, []string{"kanjidmg:v1:何"}, keys(reopened.Entries()))
+}
+
+type jishoGetterMock struct {
+	calls   int32
+	release chan struct{}
+	err     error
+}
+
+func (m *jishoGetterMock) Url(word string) string {
+	return omnikanji.JishoSearchUrl + word
+}
+
+func (m *jishoGetterMock) Get(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error) {
+	atomic.AddInt32(&m.calls, 1)
+	if m.release != nil {
+		select {
+		case <-m.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &omnikanji.JishoSection{
+		Link: m.Url(word),
+		Page: page,
+		Words: []omnikanji.JishoWordSection{{
+			FullWord: word,
+			Meanings: []omnikanji.JishoMeaning{{Meaning: omnikanji.RichText{{Text: "what", Styles: []omnikanji.RichTextStyle{omnikanji.RichTextBold}}}}},
+		}},
+	}, nil
+}
+
+func TestJisho(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("serves the cached copy", func(t *testing.T) {
+		getter := &jishoGetterMock{}
+		jisho := cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, 0)
+
+		first, err := jisho.Get(ctx, "何", 1)
+		require.NoError(t, err)
+		first.Words[0].FullWord = "modified by the caller"
+
+		second, err := jisho.Get(ctx, "何", 1)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), getter.calls)
+		require.Equal(t, "何", second.Words[0].FullWord)
+		require.Equal(t, "what", second.Words[0].Meanings[0].Meaning.String())
+
+		_, err = jisho.Get(ctx, "何", 2)
+		require.NoError(t, err)
+		require.Equal(t, int32(2), getter.calls, "pages are cached separately")
+	})
+
+	t.Run("parser version invalidates", func(t *testing.T) {
+		getter := &jishoGetterMock{}
+		store := cache.NewLRU(10)
+		_, err := cache.NewJisho(getter, store, time.Hour, 1, 0).Get(ctx, "何", 1)
+		require.NoError(t, err)
+		_, err = cache.NewJisho(getter, store, time.Hour, 2, 0).Get(ctx, "何", 1)
+		require.NoError(t, err)
+		require.Equal(t, int32(2), getter.calls)
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		getter := &jishoGetterMock{err: errors.New("upstream down")}
+		jisho := cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, 0)
+		for i := 0; i < 2; i++ {
+			_, err := jisho.Get(ctx, "何", 1)
+			require.ErrorIs(t, err, getter.err)
+		}
+		require.Equal(t, int32(2), getter.calls)
+	})
+
+	t.Run("coalesces concurrent lookups", func(t *testing.T) {
+		getter := &jishoGetterMock{release: make(chan struct{})}
+		jisho := cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, 0)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sect, err := jisho.Get(ctx, "何", 1)
+				require.NoError(t, err)
+				require.Equal(t, "何", sect.Words[0].FullWord)
+			}()
+		}
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&getter.calls) == 1 }, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond) // let the others join the lookup
+		close(getter.release)
+		wg.Wait()
+		require.Equal(t, int32(1), getter.calls)
+	})
+
+	t.Run("a caller leaving does not fail the others", func(t *testing.T) {
+		getter := &jishoGetterMock{release: make(chan struct{})}
+		jisho := cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, 0)
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		leaderErr := make(chan error)
+		go func() {
+			_, err := jisho.Get(leaderCtx, "何", 1)
+			leaderErr <- err
+		}()
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&getter.calls) == 1 }, time.Second, time.Millisecond)
+
+		followerSect := make(chan *omnikanji.JishoSection)
+		go func() {
+			sect, err := jisho.Get(ctx, "何", 1)
+			require.NoError(t, err)
+			followerSect <- sect
+		}()
+		time.Sleep(10 * time.Millisecond) // let the follower join the lookup
+		cancel()
+		require.ErrorIs(t, <-leaderErr, context.Canceled)
+
+		close(getter.release)
+		sect := <-followerSect
+		require.Equal(t, "何", sect.Words[0].FullWord)
+		require.Equal(t, int32(1), getter.calls)
+	})
+
+	t.Run("the lookup has a timeout of its own, not the first caller's", func(t *testing.T) {
+		getter := &jishoGetterMock{release: make(chan struct{})}
+		jisho := cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, 100*time.Millisecond)
+
+		leaderCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := jisho.Get(leaderCtx, "何", 1)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// the follower joins the lookup still in progress, it finishes after the leader's deadline
+		time.AfterFunc(20*time.Millisecond, func() { close(getter.release) })
+		sect, err := jisho.Get(ctx, "何", 1)
+		require.NoError(t, err)
+		require.Equal(t, "何", sect.Words[0].FullWord)
+		require.Equal(t, int32(1), getter.calls)
+
+		// and it does not wait past the timeout, e.g. a reloaded one
+		getter = &jishoGetterMock{release: make(chan struct{})}
+		jisho = cache.NewJisho(getter, cache.NewLRU(10), time.Hour, 1, time.Hour)
+		jisho.SetLookupTimeout(20 * time.Millisecond)
+		_, err = jisho.Get(ctx, "何", 1)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	redis, err := redistest.NewServer()
+	require.NoError(t, err)
+	defer redis.Close()
+
+	t.Run("shares the sections between instances", func(t *testing.T) {
+		getter := &jishoGetterMock{}
+		cfg := &omnikanji.Config{CacheSize: 10, CacheBackend: omnikanji.CacheBackendRedis, CacheRedisUrl: redis.Url()}
+		var instances []*cache.Jisho
+		for i := 0; i < 2; i++ {
+			store, err := cache.NewStore(cfg)
+			require.NoError(t, err)
+			instances = append(instances, cache.NewJisho(getter, store, time.Hour, 1, 0))
+		}
+
+		_, err := instances[0].Get(ctx, "何", 1)
+		require.NoError(t, err)
+		sect, err := instances[1].Get(ctx, "何", 1)
+		require.NoError(t, err)
+		require.Equal(t, "何", sect.Words[0].FullWord)
+		require.Equal(t, int32(1), getter.calls)
+		require.Equal(t, []string{"omnikanji:jisho:v1:何:1"}, redis.Keys())
+	})
+
+	t.Run("store", func(t *testing.T) {
+		store, err := cache.NewRedis(redis.Url() + "/1")
+		require.NoError(t, err)
+		store.Set(cache.Entry{Key: "kanjidmg:v1:何", Value: []byte(`{}`), Expires: time.Now().Add(time.Hour)})
+		store.Set(cache.Entry{Key: "kanjidmg:v1:*", Value: []byte(`{}`)})
+		store.Set(cache.Entry{Key: "kanjidmg:v1:expired", Value: []byte(`{}`), Expires: time.Now().Add(-time.Second)})
+
+		entry, ok := store.Get("kanjidmg:v1:何")
+		require.True(t, ok)
+		require.JSONEq(t, `{}`, string(entry.Value))
+		_, ok = store.Get("kanjidmg:v1:expired")
+		require.False(t, ok)
+		require.Contains(t, keys(store.Entries()), "kanjidmg:v1:*")
+
+		require.Equal(t, 1, store.Purge("kanjidmg:v1:*"), "prefixes are not patterns")
+		require.Equal(t, 1, store.Purge("kanjidmg:"))
+		require.Empty(t, store.Entries())
+	})
+
+	t.Run("falls back when redis is down", func(t *testing.T) {
+		down, err := redistest.NewServer()
+		require.NoError(t, err)
+		store, err := cache.NewStore(&omnikanji.Config{CacheSize: 10, CacheBackend: omnikanji.CacheBackendRedis, CacheRedisUrl: down.Url()})
+		require.NoError(t, err)
+		getter := &jishoGetterMock{}
+		jisho := cache.NewJisho(getter, store, time.Hour, 1, 0)
+
+		_, err = jisho.Get(ctx, "何", 1)
+		require.NoError(t, err)
+		down.Close()
+
+		sect, err := jisho.Get(ctx, "何", 1)
+		require.NoError(t, err)
+		require.Equal(t, "何", sect.Words[0].FullWord)
+		_, err = jisho.Get(ctx, "何", 2)
+		require.NoError(t, err, "lookups work without redis")
+		require.Equal(t, int32(2), getter.calls, "the memory still caches")
+	})
+}
+
+func keys(entries []cache.Entry) []string {
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Key)
+	}
+	return res
+}
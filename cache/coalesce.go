@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxSharedLookup bounds the shared lookups without a timeout of their own
+const maxSharedLookup = time.Minute
+
+// coalescer runs a single lookup for concurrent calls with the same key, the others wait for its result
+type coalescer struct {
+	timeout int64 // time.Duration, atomic
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+	panic interface{}
+}
+
+// newCoalescer bounds the lookups with timeout, maxSharedLookup if it is not positive
+func newCoalescer(timeout time.Duration) *coalescer {
+	c := &coalescer{calls: make(map[string]*call)}
+	c.setTimeout(timeout)
+	return c
+}
+
+// setTimeout applies to the lookups started after it
+func (c *coalescer) setTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = maxSharedLookup
+	}
+	atomic.StoreInt64(&c.timeout, int64(timeout))
+}
+
+// do runs fn in the background, with the values of the first caller's context but neither its cancellation
+// nor its deadline, so that a caller going away does not fail the others. The lookup has a timeout of its own
+// instead. Every caller stops waiting when its own context is done. A panic of fn is raised again in the callers.
+// The value is shared between the callers, so they must not modify it.
+func (c *coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	cl, ok := c.calls[key]
+	if !ok {
+		cl = &call{done: make(chan struct{})}
+		c.calls[key] = cl
+		shared, cancel := context.WithTimeout(detachedContext{ctx}, time.Duration(atomic.LoadInt64(&c.timeout)))
+		go func() {
+			defer cancel()
+			c.run(shared, key, cl, fn)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		if cl.panic != nil {
+			panic(cl.panic)
+		}
+		return cl.value, cl.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *coalescer) run(ctx context.Context, key string, cl *call, fn func(ctx context.Context) ([]byte, error)) {
+	defer func() {
+		cl.panic = recover()
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+	cl.value, cl.err = fn(ctx)
+}
+
+// detachedContext keeps the values of its parent, e.g. the requester of the upstream requests, but is never done
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zemiret/omnikanji"
+)
+
+type JishoGetter interface {
+	Url(word string) string
+	Get(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error)
+}
+
+type KanjidmgGetter interface {
+	Get(ctx context.Context, kanji rune) (*omnikanji.KanjidmgSection, error)
+}
+
+const (
+	JishoKeyPrefix    = "jisho:"
+	KanjidmgKeyPrefix = "kanjidmg:"
+)
+
+// Jisho caches the jisho sections. Errors, including not found, are not cached.
+type Jisho struct {
+	JishoGetter
+	cache *cached
+}
+
+// NewJisho caches the sections of getter. A lookup shared by concurrent callers runs for up to lookupTimeout,
+// whichever of them leave.
+func NewJisho(getter JishoGetter, store Store, ttl time.Duration, parserVersion int, lookupTimeout time.Duration) *Jisho {
+	return &Jisho{
+		JishoGetter: getter,
+		cache:       newCached(store, ttl, fmt.Sprintf("%sv%d:", JishoKeyPrefix, parserVersion), lookupTimeout),
+	}
+}
+
+func (j *Jisho) Get(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error) {
+	var sect omnikanji.JishoSection
+	err := j.cache.get(ctx, fmt.Sprintf("%s:%d", word, page), &sect, func(ctx context.Context) (interface{}, error) {
+		return j.JishoGetter.Get(ctx, word, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sect, nil
+}
+
+// SetLookupTimeout changes the timeout of the shared lookups, e.g. on reload
+func (j *Jisho) SetLookupTimeout(timeout time.Duration) {
+	j.cache.inflight.setTimeout(timeout)
+}
+
+// Kanjidmg caches the kanjidamage sections. Errors, including not found, are not cached.
+type Kanjidmg struct {
+	KanjidmgGetter
+	cache *cached
+}
+
+// NewKanjidmg caches the sections of getter, see NewJisho
+func NewKanjidmg(getter KanjidmgGetter, store Store, ttl time.Duration, parserVersion int, lookupTimeout time.Duration) *Kanjidmg {
+	return &Kanjidmg{
+		KanjidmgGetter: getter,
+		cache:          newCached(store, ttl, fmt.Sprintf("%sv%d:", KanjidmgKeyPrefix, parserVersion), lookupTimeout),
+	}
+}
+
+func (k *Kanjidmg) Get(ctx context.Context, kanji rune) (*omnikanji.KanjidmgSection, error) {
+	var sect omnikanji.KanjidmgSection
+	err := k.cache.get(ctx, string(kanji), &sect, func(ctx context.Context) (interface{}, error) {
+		return k.KanjidmgGetter.Get(ctx, kanji)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sect, nil
+}
+
+// SetLookupTimeout changes the timeout of the shared lookups, e.g. on reload
+func (k *Kanjidmg) SetLookupTimeout(timeout time.Duration) {
+	k.cache.inflight.setTimeout(timeout)
+}
+
+// cached looks the sections up in the store, coalescing the concurrent lookups of a missing one.
+// Every caller decodes its own copy of the section, so callers are free to modify it.
+type cached struct {
+	store     Store
+	ttl       time.Duration
+	keyPrefix string
+	inflight  *coalescer
+	now       func() time.Time
+}
+
+func newCached(store Store, ttl time.Duration, keyPrefix string, lookupTimeout time.Duration) *cached {
+	return &cached{
+		store:     store,
+		ttl:       ttl,
+		keyPrefix: keyPrefix,
+		inflight:  newCoalescer(lookupTimeout),
+		now:       time.Now,
+	}
+}
+
+func (c *cached) get(ctx context.Context, key string, dst interface{}, lookup func(ctx context.Context) (interface{}, error)) error {
+	key = c.keyPrefix + key
+	if entry, ok := c.store.Get(key); ok {
+		if err := json.Unmarshal(entry.Value, dst); err == nil {
+			return nil
+		}
+		c.store.Delete(key)
+	}
+
+	value, err := c.inflight.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		sect, err := lookup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(sect)
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{Key: key, Value: value}
+		if c.ttl > 0 {
+			entry.Expires = c.now().Add(c.ttl)
+		}
+		c.store.Set(entry)
+		return value, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(value, dst)
+}
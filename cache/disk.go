@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Disk is a store of one JSON file per entry in a directory, surviving restarts.
+// Errors are logged and treated as misses, a broken cache must not break the lookups.
+type Disk struct {
+	dir string
+	now func() time.Time
+}
+
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Disk{dir: dir, now: time.Now}, nil
+}
+
+func (d *Disk) Get(key string) (*Entry, bool) {
+	entry, err := d.read(d.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("cache: error reading %s: %s", key, err)
+		}
+		return nil, false
+	}
+	if entry.Key != key {
+		return nil, false
+	}
+	if entry.Expired(d.now()) {
+		d.Delete(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set writes the entry to a temporary file first, so that readers never see half of it
+func (d *Disk) Set(entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("cache: error encoding %s: %s", entry.Key, err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		log.Printf("cache: error writing %s: %s", entry.Key, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(entry.Key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("cache: error writing %s: %s", entry.Key, err)
+	}
+}
+
+func (d *Disk) Delete(key string) {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("cache: error deleting %s: %s", key, err)
+	}
+}
+
+func (d *Disk) Entries() []Entry {
+	var entries []Entry
+	now := d.now()
+	d.each(func(path string, entry *Entry) {
+		if !entry.Expired(now) {
+			entries = append(entries, Entry{Key: entry.Key, Expires: entry.Expires})
+		}
+	})
+	return entries
+}
+
+// Purge also deletes the expired entries, whatever their key
+func (d *Disk) Purge(prefix string) int {
+	purged := 0
+	now := d.now()
+	d.each(func(path string, entry *Entry) {
+		matches := strings.HasPrefix(entry.Key, prefix)
+		if !matches && !entry.Expired(now) {
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("cache: error deleting %s: %s", entry.Key, err)
+			return
+		}
+		if matches {
+			purged++
+		}
+	})
+	return purged
+}
+
+func (d *Disk) each(fn func(path string, entry *Entry)) {
+	paths, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		log.Printf("cache: error listing %s: %s", d.dir, err)
+		return
+	}
+	for _, path := range paths {
+		entry, err := d.read(path)
+		if err != nil {
+			log.Printf("cache: error reading %s: %s", path, err)
+			continue
+		}
+		fn(path, entry)
+	}
+}
+
+func (d *Disk) read(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// path is named after the hash of the key, keys are user input and can't be file names
+func (d *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
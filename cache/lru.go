@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRU is an in-memory store of at most size entries, evicting the least recently used ones
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Most recently used first, of *Entry
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+func (c *LRU) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*Entry)
+	if entry.Expired(c.now()) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	res := *entry
+	return &res, true
+}
+
+func (c *LRU) Set(entry Entry) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.Key]; ok {
+		el.Value = &entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(&entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *LRU) Entries() []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var entries []Entry
+	now := c.now()
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*Entry)
+		if !entry.Expired(now) {
+			entries = append(entries, Entry{Key: entry.Key, Expires: entry.Expires})
+		}
+	}
+	return entries
+}
+
+func (c *LRU) Purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*Entry).Key)
+}
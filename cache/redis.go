@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisKeyPrefix   = "omnikanji:"
+	redisTimeout     = 500 * time.Millisecond
+	redisRetryAfter  = 5 * time.Second // Redis is skipped for a while after a failure, not to slow every lookup down
+	redisMaxIdle     = 8
+	redisScanBatch   = 100
+	redisDefaultPort = "6379"
+)
+
+// Redis is a store shared by every omnikanji instance using the same Redis.
+// Like Disk, its errors are logged and treated as misses. It speaks just enough of the Redis protocol
+// for the cache: GET, SET, DEL, SCAN and MGET.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+
+	mu        sync.Mutex
+	idle      []*redisConn
+	downUntil time.Time
+	now       func() time.Time
+}
+
+// NewRedis connects lazily to the Redis of the url, e.g. redis://:password@redis:6379/0
+func NewRedis(redisUrl string) (*Redis, error) {
+	u, err := url.Parse(redisUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid redis url %q: expected redis://[:password@]host[:port][/db]", redisUrl)
+	}
+
+	r := &Redis{addr: u.Host, now: time.Now}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), redisDefaultPort)
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis db %q: %w", db, err)
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) Get(key string) (*Entry, bool) {
+	reply, err := r.do("GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, false
+	}
+	entry, err := decodeRedisEntry(reply)
+	if err != nil || entry.Key != key || entry.Expired(r.now()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set lets Redis expire the entry
+func (r *Redis) Set(entry Entry) {
+	args := []string{"SET", redisKeyPrefix + entry.Key, ""}
+	if !entry.Expires.IsZero() {
+		ttl := entry.Expires.Sub(r.now()).Milliseconds()
+		if ttl <= 0 {
+			return
+		}
+		args = append(args, "PX", strconv.FormatInt(ttl, 10))
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("cache: error encoding %s: %s", entry.Key, err)
+		return
+	}
+	args[2] = string(data)
+	r.do(args...)
+}
+
+func (r *Redis) Delete(key string) {
+	r.do("DEL", redisKeyPrefix+key)
+}
+
+func (r *Redis) Entries() []Entry {
+	var entries []Entry
+	now := r.now()
+	r.scan("", func(keys []string) {
+		reply, err := r.do(append([]string{"MGET"}, keys...)...)
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]interface{})
+		for _, v := range values {
+			if v == nil {
+				continue // expired since the scan
+			}
+			if entry, err := decodeRedisEntry(v); err == nil && !entry.Expired(now) {
+				entries = append(entries, Entry{Key: entry.Key, Expires: entry.Expires})
+			}
+		}
+	})
+	return entries
+}
+
+func (r *Redis) Purge(prefix string) int {
+	purged := 0
+	r.scan(prefix, func(keys []string) {
+		reply, err := r.do(append([]string{"DEL"}, keys...)...)
+		if n, ok := reply.(int64); err == nil && ok {
+			purged += int(n)
+		}
+	})
+	return purged
+}
+
+// scan calls fn with batches of the redis keys of the entries whose key has the prefix
+func (r *Redis) scan(prefix string, fn func(keys []string)) {
+	cursor := "0"
+	for {
+		reply, err := r.do("SCAN", cursor, "MATCH", redisKeyPrefix+redisGlobEscape(prefix)+"*", "COUNT", strconv.Itoa(redisScanBatch))
+		if err != nil {
+			return
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			log.Printf("cache: unexpected redis SCAN reply %v", reply)
+			return
+		}
+		cursor, _ = parts[0].(string)
+		var keys []string
+		batch, _ := parts[1].([]interface{})
+		for _, k := range batch {
+			if key, ok := k.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			fn(keys)
+		}
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+func decodeRedisEntry(reply interface{}) (*Entry, error) {
+	data, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	var entry Entry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// do runs a command, logging the failures. Nil replies are returned as nil.
+func (r *Redis) do(args ...string) (interface{}, error) {
+	conn, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		r.fail(err)
+		return nil, err
+	}
+	r.release(conn)
+	if err != nil {
+		log.Printf("cache: redis %s: %s", args[0], err)
+	}
+	return reply, err
+}
+
+func (r *Redis) conn() (*redisConn, error) {
+	r.mu.Lock()
+	if r.now().Before(r.downUntil) {
+		r.mu.Unlock()
+		return nil, errRedisDown
+	}
+	if n := len(r.idle); n > 0 {
+		conn := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return conn, nil
+	}
+	r.mu.Unlock()
+
+	conn, err := dialRedis(r.addr, r.password, r.db)
+	if err != nil {
+		r.fail(err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (r *Redis) release(conn *redisConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) >= redisMaxIdle {
+		conn.Close()
+		return
+	}
+	r.idle = append(r.idle, conn)
+}
+
+func (r *Redis) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log.Printf("cache: redis is unavailable, skipping it for %s: %s", redisRetryAfter, err)
+	r.downUntil = r.now().Add(redisRetryAfter)
+	for _, conn := range r.idle {
+		conn.Close()
+	}
+	r.idle = nil
+}
+
+var errRedisDown = errors.New("redis is unavailable")
+
+// redisError is an error reply of redis, the connection is still usable after it
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dialRedis(addr string, password string, db int) (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if password != "" {
+		if _, err := conn.do("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// readRedisReply reads a RESP reply: strings, int64s, nils and []interface{} of them
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		// the items after an error reply are read too, so that they are not taken for the next reply
+		items := make([]interface{}, size)
+		var itemErr error
+		for i := range items {
+			items[i], err = readRedisReply(r)
+			if _, ok := err.(redisError); ok {
+				if itemErr == nil {
+					itemErr = err
+				}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if itemErr != nil {
+			return nil, itemErr
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
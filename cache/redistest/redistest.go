@@ -0,0 +1,295 @@
+// Package redistest is an in-process stand-in for Redis, implementing the commands the cache uses.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	values  map[string]string    // by db:key
+	expires map[string]time.Time // by db:key
+	conns   map[net.Conn]bool
+	closed  bool
+}
+
+// NewServer starts listening on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Url is the redis:// url of the server
+func (s *Server) Url() string {
+	return "redis://" + s.listener.Addr().String()
+}
+
+// Close stops the server and drops its connections, like a Redis going down
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Keys are the live keys of the default db, sorted
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(0, "*")
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	db := 0
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.exec(&db, args)); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs the command in the db selected by the connection
+func (s *Server) exec(db *int, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH":
+		return "+OK\r\n"
+	case "SELECT":
+		if len(args) != 2 {
+			return wrongArgs(args)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return "-ERR DB index is out of range\r\n"
+		}
+		*db = n
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(args)
+		}
+		return s.get(dbKey(*db, args[1]))
+	case "MGET":
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			b.WriteString(s.get(dbKey(*db, key)))
+		}
+		return b.String()
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return wrongArgs(args)
+		}
+		key := dbKey(*db, args[1])
+		s.values[key] = args[2]
+		delete(s.expires, key)
+		if len(args) == 5 {
+			ms, err := strconv.Atoi(args[4])
+			if err != nil || strings.ToUpper(args[3]) != "PX" {
+				return "-ERR syntax error\r\n"
+			}
+			s.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			key = dbKey(*db, key)
+			if _, ok := s.lookup(key); ok {
+				deleted++
+			}
+			delete(s.values, key)
+			delete(s.expires, key)
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "SCAN":
+		// the whole keyspace fits in a single batch
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		keys := s.keys(*db, pattern)
+		var b strings.Builder
+		fmt.Fprintf(&b, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			b.WriteString(bulk(key))
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func (s *Server) get(key string) string {
+	value, ok := s.lookup(key)
+	if !ok {
+		return "$-1\r\n"
+	}
+	return bulk(value)
+}
+
+func (s *Server) lookup(key string) (string, bool) {
+	if expires, ok := s.expires[key]; ok && !time.Now().Before(expires) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *Server) keys(db int, pattern string) []string {
+	var keys []string
+	prefix := dbKey(db, "")
+	for k := range s.values {
+		if _, ok := s.lookup(k); !ok || !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, prefix)
+		if globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// globMatch matches like redis patterns, with * ? and \ escapes. Unlike path.Match, * also matches /.
+func globMatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(s)
+			pattern, s = pattern[1:], s[size:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if s == "" || s[0] != pattern[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+func dbKey(db int, key string) string {
+	return strconv.Itoa(db) + ":" + key
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func wrongArgs(args []string) string {
+	return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", args[0])
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected a command, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command size %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil || !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zemiret/omnikanji"
+)
+
+// Entry is a cached value. Values are the JSON of the cached sections.
+type Entry struct {
+	Key     string
+	Value   json.RawMessage
+	Expires time.Time
+}
+
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+type Store interface {
+	// Get returns the entry of the key, false if it is missing or expired
+	Get(key string) (*Entry, bool)
+	Set(entry Entry)
+	Delete(key string)
+	// Entries lists the live entries, without their values
+	Entries() []Entry
+	// Purge deletes the entries whose key has the prefix, all of them for an empty prefix.
+	// It returns the number of deleted entries.
+	Purge(prefix string) int
+}
+
+// NewStore is the in-memory LRU of the configured size, in front of the configured backend if there is one
+func NewStore(cfg *omnikanji.Config) (Store, error) {
+	lru := NewLRU(cfg.CacheSize)
+	backend, err := NewBackend(cfg)
+	if err != nil || backend == nil {
+		return lru, err
+	}
+	return NewTiered(lru, backend), nil
+}
+
+// NewBackend is the configured store behind the memory, nil if there is none
+func NewBackend(cfg *omnikanji.Config) (Store, error) {
+	switch cfg.CacheBackend {
+	case omnikanji.CacheBackendRedis:
+		return NewRedis(cfg.CacheRedisUrl)
+	case omnikanji.CacheBackendDisk, "":
+		if cfg.CacheDir == "" {
+			return nil, nil
+		}
+		return NewDisk(cfg.CacheDir)
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+}
+
+// Tiered looks the keys up in its stores in order, e.g. memory before disk.
+// Entries found in a slower store are copied into the faster ones.
+type Tiered struct {
+	stores []Store
+}
+
+func NewTiered(stores ...Store) *Tiered {
+	return &Tiered{stores: stores}
+}
+
+func (t *Tiered) Get(key string) (*Entry, bool) {
+	for i, s := range t.stores {
+		if entry, ok := s.Get(key); ok {
+			for _, faster := range t.stores[:i] {
+				faster.Set(*entry)
+			}
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+func (t *Tiered) Set(entry Entry) {
+	for _, s := range t.stores {
+		s.Set(entry)
+	}
+}
+
+func (t *Tiered) Delete(key string) {
+	for _, s := range t.stores {
+		s.Delete(key)
+	}
+}
+
+// Entries lists the entries of every store, once per key
+func (t *Tiered) Entries() []Entry {
+	var entries []Entry
+	seen := map[string]bool{}
+	for _, s := range t.stores {
+		for _, e := range s.Entries() {
+			if !seen[e.Key] {
+				seen[e.Key] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+// Purge returns the number of distinct keys deleted from any of the stores
+func (t *Tiered) Purge(prefix string) int {
+	purged := map[string]bool{}
+	for _, e := range t.Entries() {
+		if strings.HasPrefix(e.Key, prefix) {
+			purged[e.Key] = true
+		}
+	}
+	for _, s := range t.stores {
+		s.Purge(prefix)
+	}
+	return len(purged)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/cache"
+	"github.com/zemiret/omnikanji/dictproxy"
+)
+
+const usage = `Inspects and purges the disk or Redis cache of the looked up sections.
+
+Usage: cache [-dir <dir> | -redis <url>] <command> [args]
+
+Commands:
+  stats            number of entries per dictionary and parser version
+  list [prefix]    keys and expiry of the entries, e.g. list jisho:
+  get <key>        cached JSON of the entry
+  purge [prefix]   deletes the entries with the prefix, all of them without one
+  purge-stale      deletes the entries of older parser versions
+
+Running servers keep their in-memory entries until they expire or the server restarts.
+`
+
+func main() {
+	log.SetFlags(0)
+	dir := flag.String("dir", os.Getenv("CACHE_DIR"), "cache directory, defaults to CACHE_DIR")
+	redisUrl := flag.String("redis", os.Getenv("CACHE_REDIS_URL"), "redis url, defaults to CACHE_REDIS_URL. Takes precedence over -dir")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if (*dir == "" && *redisUrl == "") || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg := &omnikanji.Config{CacheBackend: omnikanji.CacheBackendDisk, CacheDir: *dir}
+	if *redisUrl != "" {
+		cfg.CacheBackend = omnikanji.CacheBackendRedis
+		cfg.CacheRedisUrl = *redisUrl
+	}
+	store, err := cache.NewBackend(cfg)
+	if err != nil {
+		log.Fatalf("error opening the cache: %s", err)
+	}
+
+	arg := flag.Arg(1)
+	switch flag.Arg(0) {
+	case "stats":
+		stats(store)
+	case "list":
+		for _, e := range sortedEntries(store, arg) {
+			expires := "never"
+			if !e.Expires.IsZero() {
+				expires = e.Expires.Format(time.RFC3339)
+			}
+			fmt.Printf("%s\t%s\n", e.Key, expires)
+		}
+	case "get":
+		entry, ok := store.Get(arg)
+		if !ok {
+			log.Fatalf("no entry %q", arg)
+		}
+		fmt.Println(string(entry.Value))
+	case "purge":
+		fmt.Printf("purged %d entries\n", store.Purge(arg))
+	case "purge-stale":
+		purged := 0
+		for _, e := range store.Entries() {
+			if !isCurrent(e.Key) {
+				store.Delete(e.Key)
+				purged++
+			}
+		}
+		fmt.Printf("purged %d entries\n", purged)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func stats(store cache.Store) {
+	counts := map[string]int{}
+	for _, e := range store.Entries() {
+		// keys are <dictionary>:v<parser version>:<query>
+		parts := strings.SplitN(e.Key, ":", 3)
+		counts[strings.Join(parts[:len(parts)-1], ":")]++
+	}
+	var groups []string
+	for g := range counts {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		fmt.Printf("%s\t%d\n", g, counts[g])
+	}
+	fmt.Printf("current parser version: v%d\n", dictproxy.ParserVersion)
+}
+
+func isCurrent(key string) bool {
+	version := fmt.Sprintf("v%d:", dictproxy.ParserVersion)
+	for _, prefix := range []string{cache.JishoKeyPrefix, cache.KanjidmgKeyPrefix} {
+		if strings.HasPrefix(key, prefix+version) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedEntries(store cache.Store, prefix string) []cache.Entry {
+	var entries []cache.Entry
+	for _, e := range store.Entries() {
+		if strings.HasPrefix(e.Key, prefix) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
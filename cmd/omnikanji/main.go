@@ -1,37 +1,158 @@
 package main
 
 import (
-	"html/template"
+	"context"
+	"errors"
+	"flag"
 	"log"
-	"path/filepath"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/cache"
 	"github.com/zemiret/omnikanji/dictproxy"
 	"github.com/zemiret/omnikanji/pkg/http"
 	"github.com/zemiret/omnikanji/server"
 )
 
-// TODO: Periodic refresh of kanjidmg list of kanjis (once every month is probably enough)
-
 func main() {
-	cfg := omnikanji.ParseEnvConfig()
-
-	idxTplPath, err := filepath.Abs("server/index.html")
+	cfg, printConfig, err := omnikanji.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
+	if printConfig {
+		dump, err := cfg.Dump()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(dump)
+		return
+	}
+
+	httpClient := newHttpClient(cfg)
 
-	indexTemplate := template.Must(template.ParseFiles(idxTplPath))
+	jishoClient := dictproxy.NewFailover(dictproxy.SourceJisho, httpClient, cfg.JishoBaseUrl,
+		cfg.JishoMirrors, cfg.BreakerFailures, cfg.BreakerCooldown)
+	kanjidmgClient := dictproxy.NewFailover(dictproxy.SourceKanjidmg, httpClient, cfg.KanjidmgBaseUrl,
+		cfg.KanjidmgMirrors, cfg.BreakerFailures, cfg.BreakerCooldown)
 
-	httpClient := http.NewClient()
+	// Without an index, kanjidamage is unavailable until the first refresh, jisho works meanwhile
+	kanjidmgProxy := dictproxy.NewKanjidmg(cfg.KanjidmgBaseUrl, nil, kanjidmgClient)
+	if cfg.KanjidmgIndexFile != "" {
+		if err := kanjidmgProxy.LoadIndexSnapshot(cfg.KanjidmgIndexFile); err != nil {
+			log.Printf("error loading kanjidamage index snapshot: %s", err)
+		}
+	}
+	go kanjidmgProxy.KeepIndexFresh(context.Background(), cfg.KanjidmgIndexRefresh, cfg.KanjidmgIndexFile)
 
-	kanjidmgLinks, err := dictproxy.LoadKanjidmgLinks(httpClient)
+	var jisho server.JishoSectionGetter = dictproxy.NewJisho(cfg.JishoSearchUrl(), jishoClient)
+	var kanjidmg server.KanjidmgSectionGetter = kanjidmgProxy
+	if cfg.CacheSize > 0 {
+		store, err := cache.NewStore(cfg)
+		if err != nil {
+			log.Fatal("error opening the cache: " + err.Error())
+		}
+		jisho = cache.NewJisho(jisho, store, cfg.CacheTTL, dictproxy.ParserVersion, cfg.JishoTimeout)
+		kanjidmg = cache.NewKanjidmg(kanjidmg, store, cfg.CacheTTL, dictproxy.ParserVersion, cfg.KanjidmgTimeout)
+	}
+	srv, err := server.NewServer(cfg, jisho, kanjidmg)
 	if err != nil {
-		log.Fatal("error getting kanjidamage kanji list: " + err.Error())
+		log.Fatal(err)
+	}
+	go reloadOnSighup(cfg, func(cfg *omnikanji.Config) {
+		srv.SetConfig(cfg)
+		if cached, ok := jisho.(*cache.Jisho); ok {
+			cached.SetLookupTimeout(cfg.JishoTimeout)
+		}
+		if cached, ok := kanjidmg.(*cache.Kanjidmg); ok {
+			cached.SetLookupTimeout(cfg.KanjidmgTimeout)
+		}
+	})
+	shutdown := make(chan struct{})
+	go func() {
+		shutdownOnSigterm(cfg.ShutdownTimeout, srv.Shutdown)
+		close(shutdown)
+	}()
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+	<-shutdown
+	log.Println("Server stopped.")
+}
+
+// shutdownOnSigterm shuts down on SIGTERM or SIGINT, waiting up to timeout for the requests in progress.
+// A second signal does not wait.
+func shutdownOnSigterm(timeout time.Duration, shutdown func(ctx context.Context) error) {
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	<-term
+	log.Printf("Shutting down, waiting up to %s for the requests in progress...", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		<-term
+		cancel()
+	}()
+	if err := shutdown(ctx); err != nil {
+		log.Printf("error shutting down: %s", err)
+	}
+}
+
+// reloadOnSighup loads the config again on SIGHUP, with the same flags, and applies what can be applied
+// while running. A config that does not load or validate is ignored.
+func reloadOnSighup(cfg *omnikanji.Config, apply func(*omnikanji.Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Println("Reloading config...")
+		next, _, err := omnikanji.LoadConfig(os.Args[1:])
+		if err != nil {
+			log.Printf("error reloading config, keeping the current one: %s", err)
+			continue
+		}
+		var restart []string
+		cfg, restart = cfg.Reload(next)
+		for _, key := range restart {
+			log.Printf("%s changed, it applies after a restart", key)
+		}
+		apply(cfg)
+		log.Println("Config reloaded.")
 	}
+}
 
-	jisho := dictproxy.NewJisho(omnikanji.JishoSearchUrl, httpClient)
-	kanjidmg := dictproxy.NewKanjidmg(kanjidmgLinks, httpClient)
-	srv := server.NewServer(cfg, indexTemplate, jisho, kanjidmg)
-	srv.Start()
+// newHttpClient limits the upstream requests as configured
+func newHttpClient(cfg *omnikanji.Config) *http.Client {
+	opts := []http.Option{
+		http.WithMaxConcurrent(cfg.UpstreamMaxConcurrent),
+		http.WithDefaultRate(http.Rate{PerSecond: cfg.UpstreamRate, Burst: cfg.UpstreamBurst}),
+		http.WithConnectTimeout(cfg.UpstreamConnectTimeout),
+		http.WithReadTimeout(cfg.UpstreamReadTimeout),
+		http.WithRetry(http.Retry{
+			Attempts:   cfg.UpstreamRetries,
+			Backoff:    cfg.UpstreamRetryBackoff,
+			MaxBackoff: 8 * cfg.UpstreamRetryBackoff,
+		}),
+		http.WithMaxBodySize(int64(cfg.UpstreamMaxBodySize)),
+	}
+	if cfg.UpstreamUserAgent != "" {
+		opts = append(opts, http.WithUserAgent(cfg.UpstreamUserAgent))
+	}
+	if cfg.UpstreamProxy != "" {
+		proxy, err := url.Parse(cfg.UpstreamProxy)
+		if err != nil {
+			log.Fatal("invalid upstream proxy: " + err.Error())
+		}
+		opts = append(opts, http.WithProxy(proxy))
+	}
+	for host, rate := range cfg.UpstreamHostRates {
+		opts = append(opts, http.WithHostRate(host, http.Rate{PerSecond: rate, Burst: cfg.UpstreamBurst}))
+	}
+	return http.NewClient(opts...)
 }
@@ -1,11 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
-	"time"
 
 	"github.com/zemiret/omnikanji"
 	"github.com/zemiret/omnikanji/dictproxy"
@@ -16,12 +15,14 @@ import (
 func main() {
 	log.Println("Loading fixture")
 
-	httpClient := http.NewClient()
-	kanjidmgLinks, err := dictproxy.LoadKanjidmgLinks(httpClient)
+	ctx := context.Background()
+	// be nice to DOS attack detectors
+	httpClient := http.NewClient(http.WithDefaultRate(http.Rate{PerSecond: 1}))
+	kanjidmgLinks, err := dictproxy.LoadKanjidmgLinks(ctx, omnikanji.KanjidmgBaseUrl, httpClient)
 	if err != nil {
 		log.Fatalf("LoadKanjidmgLinks: %s", err)
 	}
-	kanjidmg := dictproxy.NewKanjidmg(kanjidmgLinks, httpClient)
+	kanjidmg := dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, kanjidmgLinks, httpClient)
 	jisho := dictproxy.NewJisho(omnikanji.JishoSearchUrl, httpClient)
 
 	serverFixtureDir := "./server/fixture"
@@ -45,7 +46,7 @@ func main() {
 	for _, word := range lookups {
 		log.Printf("Looking up: %s", word)
 
-		resp, err := httpClient.Get(jisho.Url(word))
+		resp, err := httpClient.Get(ctx, jisho.Url(word))
 		if err != nil {
 			log.Fatalf("Error getting %s: %s", word, err)
 		}
@@ -62,7 +63,7 @@ func main() {
 			if !jptext.IsKanji(r) {
 				continue
 			}
-			resp, err := httpClient.Get(kanjidmg.Url(string(r)))
+			resp, err := httpClient.Get(ctx, kanjidmg.Url(string(r)))
 			if err != nil {
 				log.Fatalf("Error getting %s: %s", word, err)
 			}
@@ -75,9 +76,5 @@ func main() {
 			defer f.Close()
 			f.ReadFrom(resp.Body)
 		}
-
-		sleeptime := time.Duration(float32(time.Second) * (rand.Float32() + 0.5))
-		log.Printf("Sleeping for: %dms", sleeptime.Milliseconds())
-		time.Sleep(sleeptime) // be nice to DOS attack detectors
 	}
 }
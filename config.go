@@ -1,31 +1,306 @@
 package omnikanji
 
 import (
-	"log"
+	"fmt"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/zemiret/omnikanji/jptext"
 )
 
+// Config is layered: the defaults, then the YAML file, then the env and then the flags, see LoadConfig.
+// The yaml keys are the env names in lower case, and the flags the same with dashes, e.g. jisho_timeout,
+// JISHO_TIMEOUT and -jisho-timeout.
 type Config struct {
-	DebugMode bool
+	// ListenAddr is the address the server listens at, e.g. :8080
+	ListenAddr string `yaml:"listen_addr"`
+	// BasePath is the path the site is served under, e.g. /omnikanji when a larger service mounts it there.
+	// Empty serves it at the root. It is not reloaded, the routes and the links are built with it on startup.
+	BasePath string `yaml:"base_path"`
+	// ServerReadTimeout limits reading a request, ServerWriteTimeout writing its response, streams included,
+	// and ServerIdleTimeout how long a keep-alive connection waits for the next request. Zero means no timeout.
+	ServerReadTimeout  time.Duration `yaml:"server_read_timeout"`
+	ServerWriteTimeout time.Duration `yaml:"server_write_timeout"`
+	ServerIdleTimeout  time.Duration `yaml:"server_idle_timeout"`
+	// ShutdownTimeout is how long the requests in progress are waited for on SIGTERM, before their lookups
+	// are cancelled. Keep it below the grace period of the container, 10s by default in docker.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ThemeDir overrides the built-in page template and css with its index.html and css/ files, e.g. css/main.css
+	ThemeDir string `yaml:"theme_dir"`
+	// AssetsDir is where the sources of the built-in assets are. In debug mode the page is read from there on every
+	// request, so that changes show up without a restart.
+	AssetsDir string `yaml:"assets_dir"`
+
+	DebugMode bool `yaml:"debug"`
+	// Streaming makes the search page load empty and receive each dictionary's results as soon as they arrive
+	Streaming bool `yaml:"streaming"`
+
+	// JishoBaseUrl and KanjidmgBaseUrl are where the dictionaries are looked up
+	JishoBaseUrl    string `yaml:"jisho_base_url"`
+	KanjidmgBaseUrl string `yaml:"kanjidmg_base_url"`
+
+	// Timeouts for a single lookup in each source. Whatever finished before the timeout is shown as partial results.
+	// Zero means no timeout.
+	JishoTimeout    time.Duration `yaml:"jisho_timeout"`
+	KanjidmgTimeout time.Duration `yaml:"kanjidmg_timeout"`
+
+	// JishoWordsShown is how many jisho words are shown expanded, the rest is collapsed. Zero or less shows all of them.
+	JishoWordsShown int `yaml:"jisho_words_shown"`
+
+	// CacheSize is how many looked up sections are kept in memory. Zero or less disables the cache.
+	CacheSize int `yaml:"cache_size"`
+	// CacheBackend is where the sections are kept behind the memory: CacheBackendDisk or CacheBackendRedis.
+	CacheBackend string `yaml:"cache_backend"`
+	// CacheDir keeps the looked up sections on disk too, so that they survive restarts. Empty means memory only.
+	CacheDir string `yaml:"cache_dir"`
+	// CacheRedisUrl is the Redis shared by the instances, e.g. redis://:password@redis:6379/0
+	CacheRedisUrl string `yaml:"cache_redis_url"`
+	// CacheTTL is how long a section is served from the cache. Zero means forever.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// UpstreamMaxConcurrent caps the requests in flight to jisho and kanjidamage together. Zero or less is unlimited.
+	UpstreamMaxConcurrent int `yaml:"upstream_max_concurrent"`
+	// UpstreamRate is how many requests per second are made to a single host, with bursts of up to UpstreamBurst.
+	// Zero or less is unlimited.
+	UpstreamRate  float64 `yaml:"upstream_rate"`
+	UpstreamBurst int     `yaml:"upstream_burst"`
+	// UpstreamHostRates overrides UpstreamRate for the hosts, e.g. jisho.org
+	UpstreamHostRates map[string]float64 `yaml:"upstream_host_rates"`
+
+	// UpstreamConnectTimeout limits connecting to the upstream, UpstreamReadTimeout waiting for each part of its response.
+	UpstreamConnectTimeout time.Duration `yaml:"upstream_connect_timeout"`
+	UpstreamReadTimeout    time.Duration `yaml:"upstream_read_timeout"`
+	// UpstreamRetries is how many times a failed request is retried, waiting UpstreamRetryBackoff doubled after each retry,
+	// or the Retry-After the upstream asked for.
+	UpstreamRetries      int           `yaml:"upstream_retries"`
+	UpstreamRetryBackoff time.Duration `yaml:"upstream_retry_backoff"`
+	// UpstreamUserAgent is the User-Agent of the requests
+	UpstreamUserAgent string `yaml:"upstream_user_agent"`
+	// UpstreamProxy is the proxy the requests go through. Empty uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY env.
+	UpstreamProxy string `yaml:"upstream_proxy"`
+	// UpstreamMaxBodySize is the max size of a response, in bytes. Zero or less is unlimited.
+	UpstreamMaxBodySize int `yaml:"upstream_max_body_size"`
+
+	// BreakerFailures is how many consecutive failures of an upstream make it skipped for BreakerCooldown.
+	// Zero or less never skips it.
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+	// JishoMirrors and KanjidmgMirrors are the base urls tried when the dictionary fails, in order,
+	// e.g. an archive of http://www.kanjidamage.com
+	JishoMirrors    []string `yaml:"jisho_mirrors"`
+	KanjidmgMirrors []string `yaml:"kanjidmg_mirrors"`
+
+	// KanjidmgIndexFile keeps the kanjidamage kanji list, so that kanjis are looked up right after a restart
+	// even when kanjidamage is down. Empty means memory only.
+	KanjidmgIndexFile string `yaml:"kanjidmg_index_file"`
+	// KanjidmgIndexRefresh is how old the kanji list gets before it is reloaded from kanjidamage
+	KanjidmgIndexRefresh time.Duration `yaml:"kanjidmg_index_refresh"`
 }
 
-func ParseEnvConfig() *Config {
-	cfg := &Config{}
-	log.Println("Parsing env config...")
-	if os.Getenv("DEBUG") != "" {
-		log.Println("DEBUG=true")
-		cfg.DebugMode = true
+const (
+	DefaultListenAddr = ":8080"
+	DefaultAssetsDir  = "server"
+
+	DefaultServerReadTimeout  = 10 * time.Second
+	DefaultServerWriteTimeout = time.Minute
+	DefaultServerIdleTimeout  = 2 * time.Minute
+	DefaultShutdownTimeout    = 8 * time.Second
+
+	DefaultJishoTimeout    = 5 * time.Second
+	DefaultKanjidmgTimeout = 5 * time.Second
+
+	DefaultJishoWordsShown = 5
+
+	CacheBackendDisk  = "disk"
+	CacheBackendRedis = "redis"
+
+	DefaultCacheSize = 1000
+	DefaultCacheTTL  = 24 * time.Hour
+
+	DefaultUpstreamMaxConcurrent = 8
+	DefaultUpstreamRate          = 5
+	DefaultUpstreamBurst         = 10
+
+	DefaultUpstreamConnectTimeout = 3 * time.Second
+	DefaultUpstreamReadTimeout    = 5 * time.Second
+	DefaultUpstreamRetries        = 2
+	DefaultUpstreamRetryBackoff   = 200 * time.Millisecond
+	DefaultUpstreamMaxBodySize    = 5 << 20
+
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+
+	DefaultKanjidmgIndexRefresh = 30 * 24 * time.Hour
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr: DefaultListenAddr,
+		AssetsDir:  DefaultAssetsDir,
+
+		ServerReadTimeout:  DefaultServerReadTimeout,
+		ServerWriteTimeout: DefaultServerWriteTimeout,
+		ServerIdleTimeout:  DefaultServerIdleTimeout,
+		ShutdownTimeout:    DefaultShutdownTimeout,
+
+		JishoBaseUrl:    JishoBaseUrl,
+		KanjidmgBaseUrl: KanjidmgBaseUrl,
+
+		JishoTimeout:    DefaultJishoTimeout,
+		KanjidmgTimeout: DefaultKanjidmgTimeout,
+		JishoWordsShown: DefaultJishoWordsShown,
+		CacheSize:       DefaultCacheSize,
+		CacheTTL:        DefaultCacheTTL,
+		CacheBackend:    CacheBackendDisk,
+
+		UpstreamMaxConcurrent: DefaultUpstreamMaxConcurrent,
+		UpstreamRate:          DefaultUpstreamRate,
+		UpstreamBurst:         DefaultUpstreamBurst,
+
+		UpstreamConnectTimeout: DefaultUpstreamConnectTimeout,
+		UpstreamReadTimeout:    DefaultUpstreamReadTimeout,
+		UpstreamRetries:        DefaultUpstreamRetries,
+		UpstreamRetryBackoff:   DefaultUpstreamRetryBackoff,
+		UpstreamMaxBodySize:    DefaultUpstreamMaxBodySize,
+
+		BreakerFailures: DefaultBreakerFailures,
+		BreakerCooldown: DefaultBreakerCooldown,
+
+		KanjidmgIndexRefresh: DefaultKanjidmgIndexRefresh,
+	}
+}
+
+// Validate reports all the invalid settings at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, v ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, v...))
+		}
+	}
+
+	check(c.ListenAddr != "", "listen_addr is empty")
+	check(c.BasePath == "" || (strings.HasPrefix(c.BasePath, "/") && !strings.HasSuffix(c.BasePath, "/")),
+		"base_path=%q: expected a path like /omnikanji, without the trailing slash", c.BasePath)
+	if c.ThemeDir != "" {
+		info, err := os.Stat(c.ThemeDir)
+		check(err == nil && info.IsDir(), "theme_dir=%q is not a directory", c.ThemeDir)
+	}
+	check(isHttpUrl(c.JishoBaseUrl), "jisho_base_url=%q is not a http(s) url", c.JishoBaseUrl)
+	check(isHttpUrl(c.KanjidmgBaseUrl), "kanjidmg_base_url=%q is not a http(s) url", c.KanjidmgBaseUrl)
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"server_read_timeout", c.ServerReadTimeout},
+		{"server_write_timeout", c.ServerWriteTimeout},
+		{"server_idle_timeout", c.ServerIdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"jisho_timeout", c.JishoTimeout},
+		{"kanjidmg_timeout", c.KanjidmgTimeout},
+		{"cache_ttl", c.CacheTTL},
+		{"upstream_connect_timeout", c.UpstreamConnectTimeout},
+		{"upstream_read_timeout", c.UpstreamReadTimeout},
+		{"upstream_retry_backoff", c.UpstreamRetryBackoff},
+		{"breaker_cooldown", c.BreakerCooldown},
+		{"kanjidmg_index_refresh", c.KanjidmgIndexRefresh},
+	} {
+		check(d.d >= 0, "%s=%s is negative", d.name, d.d)
+	}
+
+	check(c.CacheBackend == CacheBackendDisk || c.CacheBackend == CacheBackendRedis,
+		"cache_backend=%q: expected %s or %s", c.CacheBackend, CacheBackendDisk, CacheBackendRedis)
+	if c.CacheBackend == CacheBackendRedis {
+		check(c.CacheRedisUrl != "", "cache_backend=%s needs cache_redis_url", CacheBackendRedis)
+	}
+	if c.CacheRedisUrl != "" {
+		u, err := url.Parse(c.CacheRedisUrl)
+		check(err == nil && u.Scheme == "redis" && u.Host != "", "cache_redis_url is not a redis:// url")
+	}
+
+	check(c.UpstreamRate >= 0, "upstream_rate=%g is negative", c.UpstreamRate)
+	check(c.UpstreamBurst >= 0, "upstream_burst=%d is negative", c.UpstreamBurst)
+	for host, rate := range c.UpstreamHostRates {
+		check(host != "" && rate >= 0, "upstream_host_rates: invalid %s=%g", host, rate)
+	}
+	check(c.UpstreamRetries >= 0, "upstream_retries=%d is negative", c.UpstreamRetries)
+	if c.UpstreamProxy != "" {
+		u, err := url.Parse(c.UpstreamProxy)
+		check(err == nil && u.Host != "", "upstream_proxy is not a url like http://proxy:3128")
+	}
+	for _, mirror := range c.JishoMirrors {
+		check(isHttpUrl(mirror), "jisho_mirrors: %q is not a http(s) url", mirror)
+	}
+	for _, mirror := range c.KanjidmgMirrors {
+		check(isHttpUrl(mirror), "kanjidmg_mirrors: %q is not a http(s) url", mirror)
 	}
-	log.Println("Config parsed.")
 
-	return cfg
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func isHttpUrl(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// reloadable are the settings applied by Reload, the others are only read on startup
+var reloadable = map[string]bool{
+	"DebugMode":       true,
+	"Streaming":       true,
+	"JishoTimeout":    true,
+	"KanjidmgTimeout": true,
+	"JishoWordsShown": true,
+}
+
+// Reload is the config with the reloadable settings of next. Restart lists the yaml keys
+// of the other settings that changed and need a restart to apply.
+func (c *Config) Reload(next *Config) (reloaded *Config, restart []string) {
+	res := *c
+	cur := reflect.ValueOf(&res).Elem()
+	nextVal := reflect.ValueOf(next).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		field := cur.Type().Field(i)
+		if reloadable[field.Name] {
+			cur.Field(i).Set(nextVal.Field(i))
+		} else if !reflect.DeepEqual(cur.Field(i).Interface(), nextVal.Field(i).Interface()) {
+			restart = append(restart, field.Tag.Get("yaml"))
+		}
+	}
+	return &res, restart
+}
+
+// JishoSearchUrl is the base of the jisho searches, e.g. https://jisho.org/search/
+func (c *Config) JishoSearchUrl() string {
+	return strings.TrimSuffix(c.JishoBaseUrl, "/") + "/search/"
 }
 
 const (
-	JishoSearchUrl = "https://jisho.org/search/"
+	JishoBaseUrl   = "https://jisho.org"
+	JishoSearchUrl = JishoBaseUrl + "/search/"
 
 	KanjidmgBaseUrl = "http://www.kanjidamage.com"
 	KanjidmgListUrl = KanjidmgBaseUrl + "/kanji"
 
+	WordPath  = "/w/"
+	KanjiPath = "/k/"
+	// SearchPath is where the search form submits, it redirects to the WordPath permalink
+	SearchPath     = "/search/"
 	QuerySearchKey = "word"
+	QueryPageKey   = "page"
 )
+
+// SearchUrl is the omnikanji permalink of the word, built from the normalised word
+func SearchUrl(word string) string {
+	return WordPath + url.PathEscape(jptext.NormalizeQuery(word))
+}
+
+// KanjiUrl is the omnikanji page of a single kanji, with its kanjidamage entry only
+func KanjiUrl(kanji string) string {
+	return KanjiPath + url.PathEscape(kanji)
+}
@@ -0,0 +1,109 @@
+package omnikanji_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zemiret/omnikanji"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "omnikanji.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, printConfig, err := omnikanji.LoadConfig(nil)
+		require.NoError(t, err)
+		require.False(t, printConfig)
+		require.Equal(t, omnikanji.DefaultConfig(), cfg)
+	})
+
+	t.Run("flags over env over file", func(t *testing.T) {
+		path := writeConfigFile(t, `
+listen_addr: ":9090"
+jisho_timeout: 1s
+kanjidmg_timeout: 1s
+cache_size: 10
+upstream_host_rates:
+  jisho.org: 2
+`)
+		t.Setenv("CONFIG_FILE", path)
+		t.Setenv("JISHO_TIMEOUT", "2s")
+		t.Setenv("KANJIDMG_TIMEOUT", "2s")
+		t.Setenv("DEBUG", "yes")
+
+		cfg, printConfig, err := omnikanji.LoadConfig([]string{"-kanjidmg-timeout", "3s", "-streaming", "--print-config"})
+		require.NoError(t, err)
+		require.True(t, printConfig)
+		require.Equal(t, ":9090", cfg.ListenAddr)
+		require.Equal(t, 10, cfg.CacheSize)
+		require.Equal(t, map[string]float64{"jisho.org": 2}, cfg.UpstreamHostRates)
+		require.Equal(t, 2*time.Second, cfg.JishoTimeout)
+		require.Equal(t, 3*time.Second, cfg.KanjidmgTimeout)
+		require.True(t, cfg.DebugMode)
+		require.True(t, cfg.Streaming)
+		require.Equal(t, omnikanji.DefaultCacheTTL, cfg.CacheTTL)
+	})
+
+	t.Run("unknown keys are errors", func(t *testing.T) {
+		_, _, err := omnikanji.LoadConfig([]string{"-config", writeConfigFile(t, "listen_adr: :9090\n")})
+		require.ErrorContains(t, err, "listen_adr")
+	})
+
+	t.Run("invalid values are errors", func(t *testing.T) {
+		_, _, err := omnikanji.LoadConfig([]string{"-cache-size", "many"})
+		require.ErrorContains(t, err, "-cache-size")
+
+		t.Setenv("UPSTREAM_HOST_RATES", "jisho.org")
+		_, _, err = omnikanji.LoadConfig(nil)
+		require.ErrorContains(t, err, "UPSTREAM_HOST_RATES")
+	})
+
+	t.Run("validates", func(t *testing.T) {
+		_, _, err := omnikanji.LoadConfig([]string{"-cache-backend", "redis", "-jisho-base-url", "jisho.org", "-cache-ttl", "-1h"})
+		require.ErrorContains(t, err, "cache_backend=redis needs cache_redis_url")
+		require.ErrorContains(t, err, "jisho_base_url")
+		require.ErrorContains(t, err, "cache_ttl")
+	})
+}
+
+func TestConfigDump(t *testing.T) {
+	cfg := omnikanji.DefaultConfig()
+	cfg.CacheBackend = omnikanji.CacheBackendRedis
+	cfg.CacheRedisUrl = "redis://:secret@redis:6379/0"
+	cfg.KanjidmgMirrors = []string{"https://mirror.example"}
+
+	dump, err := cfg.Dump()
+	require.NoError(t, err)
+	require.NotContains(t, string(dump), "secret")
+
+	loaded, _, err := omnikanji.LoadConfig([]string{"-config", writeConfigFile(t, string(dump))})
+	require.NoError(t, err)
+	require.Equal(t, cfg.KanjidmgMirrors, loaded.KanjidmgMirrors)
+	require.Equal(t, cfg.CacheTTL, loaded.CacheTTL)
+}
+
+func TestConfigReload(t *testing.T) {
+	cfg := omnikanji.DefaultConfig()
+	next := omnikanji.DefaultConfig()
+	next.JishoTimeout = time.Second
+	next.DebugMode = true
+	next.ListenAddr = ":9090"
+	next.CacheSize = 0
+	next.BasePath = "/omnikanji"
+
+	reloaded, restart := cfg.Reload(next)
+	require.Equal(t, time.Second, reloaded.JishoTimeout)
+	require.True(t, reloaded.DebugMode)
+	require.Equal(t, omnikanji.DefaultListenAddr, reloaded.ListenAddr)
+	require.Equal(t, omnikanji.DefaultCacheSize, reloaded.CacheSize)
+	require.Empty(t, reloaded.BasePath)
+	require.Equal(t, []string{"listen_addr", "base_path", "cache_size"}, restart)
+	require.Equal(t, omnikanji.DefaultJishoTimeout, cfg.JishoTimeout)
+}
@@ -0,0 +1,275 @@
+package omnikanji
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a Config field settable by the env and the flags
+type setting struct {
+	env    string
+	usage  string
+	secret bool // not logged
+	value  func(c *Config) flag.Value
+}
+
+var settings = []setting{
+	{env: "LISTEN_ADDR", usage: "address the server listens at", value: func(c *Config) flag.Value { return (*stringValue)(&c.ListenAddr) }},
+	{env: "BASE_PATH", usage: "path the site is served under, e.g. /omnikanji", value: func(c *Config) flag.Value { return (*stringValue)(&c.BasePath) }},
+	{env: "SERVER_READ_TIMEOUT", usage: "timeout of reading a request, 0 for none", value: func(c *Config) flag.Value { return (*durationValue)(&c.ServerReadTimeout) }},
+	{env: "SERVER_WRITE_TIMEOUT", usage: "timeout of writing a response, 0 for none", value: func(c *Config) flag.Value { return (*durationValue)(&c.ServerWriteTimeout) }},
+	{env: "SERVER_IDLE_TIMEOUT", usage: "timeout of an idle keep-alive connection, 0 for none", value: func(c *Config) flag.Value { return (*durationValue)(&c.ServerIdleTimeout) }},
+	{env: "SHUTDOWN_TIMEOUT", usage: "wait for the requests in progress on shutdown, 0 does not wait", value: func(c *Config) flag.Value { return (*durationValue)(&c.ShutdownTimeout) }},
+	{env: "THEME_DIR", usage: "directory overriding the built-in index.html and css/", value: func(c *Config) flag.Value { return (*stringValue)(&c.ThemeDir) }},
+	{env: "ASSETS_DIR", usage: "sources of the built-in assets, read in debug mode", value: func(c *Config) flag.Value { return (*stringValue)(&c.AssetsDir) }},
+	{env: "DEBUG", usage: "log the rendered data and the upstream waits", value: func(c *Config) flag.Value { return (*boolValue)(&c.DebugMode) }},
+	{env: "STREAMING", usage: "stream the results to the page as they arrive", value: func(c *Config) flag.Value { return (*boolValue)(&c.Streaming) }},
+	{env: "JISHO_BASE_URL", usage: "where jisho is looked up", value: func(c *Config) flag.Value { return (*stringValue)(&c.JishoBaseUrl) }},
+	{env: "KANJIDMG_BASE_URL", usage: "where kanjidamage is looked up", value: func(c *Config) flag.Value { return (*stringValue)(&c.KanjidmgBaseUrl) }},
+	{env: "JISHO_TIMEOUT", usage: "timeout of a jisho lookup, 0 for none", value: func(c *Config) flag.Value { return (*durationValue)(&c.JishoTimeout) }},
+	{env: "KANJIDMG_TIMEOUT", usage: "timeout of the kanjidamage lookups, 0 for none", value: func(c *Config) flag.Value { return (*durationValue)(&c.KanjidmgTimeout) }},
+	{env: "JISHO_WORDS_SHOWN", usage: "jisho words shown expanded, 0 for all", value: func(c *Config) flag.Value { return (*intValue)(&c.JishoWordsShown) }},
+	{env: "CACHE_SIZE", usage: "sections cached in memory, 0 disables the cache", value: func(c *Config) flag.Value { return (*intValue)(&c.CacheSize) }},
+	{env: "CACHE_BACKEND", usage: "cache behind the memory: disk or redis", value: func(c *Config) flag.Value { return (*stringValue)(&c.CacheBackend) }},
+	{env: "CACHE_DIR", usage: "directory of the disk cache", value: func(c *Config) flag.Value { return (*stringValue)(&c.CacheDir) }},
+	{env: "CACHE_REDIS_URL", usage: "url of the redis cache", secret: true, value: func(c *Config) flag.Value { return (*stringValue)(&c.CacheRedisUrl) }},
+	{env: "CACHE_TTL", usage: "how long sections are cached, 0 for forever", value: func(c *Config) flag.Value { return (*durationValue)(&c.CacheTTL) }},
+	{env: "UPSTREAM_MAX_CONCURRENT", usage: "upstream requests in flight, 0 for unlimited", value: func(c *Config) flag.Value { return (*intValue)(&c.UpstreamMaxConcurrent) }},
+	{env: "UPSTREAM_RATE", usage: "requests per second to a host, 0 for unlimited", value: func(c *Config) flag.Value { return (*floatValue)(&c.UpstreamRate) }},
+	{env: "UPSTREAM_BURST", usage: "requests to a host in a burst", value: func(c *Config) flag.Value { return (*intValue)(&c.UpstreamBurst) }},
+	{env: "UPSTREAM_HOST_RATES", usage: "rates of some hosts, e.g. jisho.org=2,www.kanjidamage.com=5", value: func(c *Config) flag.Value { return (*hostRatesValue)(&c.UpstreamHostRates) }},
+	{env: "UPSTREAM_CONNECT_TIMEOUT", usage: "timeout of connecting upstream", value: func(c *Config) flag.Value { return (*durationValue)(&c.UpstreamConnectTimeout) }},
+	{env: "UPSTREAM_READ_TIMEOUT", usage: "timeout of each read of an upstream response", value: func(c *Config) flag.Value { return (*durationValue)(&c.UpstreamReadTimeout) }},
+	{env: "UPSTREAM_RETRIES", usage: "retries of a failed upstream request", value: func(c *Config) flag.Value { return (*intValue)(&c.UpstreamRetries) }},
+	{env: "UPSTREAM_RETRY_BACKOFF", usage: "wait before the first retry", value: func(c *Config) flag.Value { return (*durationValue)(&c.UpstreamRetryBackoff) }},
+	{env: "UPSTREAM_USER_AGENT", usage: "User-Agent of the upstream requests", value: func(c *Config) flag.Value { return (*stringValue)(&c.UpstreamUserAgent) }},
+	{env: "UPSTREAM_PROXY", usage: "proxy of the upstream requests", secret: true, value: func(c *Config) flag.Value { return (*stringValue)(&c.UpstreamProxy) }},
+	{env: "UPSTREAM_MAX_BODY_SIZE", usage: "max size of an upstream response in bytes, 0 for unlimited", value: func(c *Config) flag.Value { return (*intValue)(&c.UpstreamMaxBodySize) }},
+	{env: "BREAKER_FAILURES", usage: "consecutive failures skipping an upstream, 0 never skips", value: func(c *Config) flag.Value { return (*intValue)(&c.BreakerFailures) }},
+	{env: "BREAKER_COOLDOWN", usage: "how long a failing upstream is skipped", value: func(c *Config) flag.Value { return (*durationValue)(&c.BreakerCooldown) }},
+	{env: "JISHO_MIRRORS", usage: "base urls tried when jisho fails, separated by commas", value: func(c *Config) flag.Value { return (*listValue)(&c.JishoMirrors) }},
+	{env: "KANJIDMG_MIRRORS", usage: "base urls tried when kanjidamage fails, separated by commas", value: func(c *Config) flag.Value { return (*listValue)(&c.KanjidmgMirrors) }},
+	{env: "KANJIDMG_INDEX_FILE", usage: "file keeping the kanjidamage kanji list", value: func(c *Config) flag.Value { return (*stringValue)(&c.KanjidmgIndexFile) }},
+	{env: "KANJIDMG_INDEX_REFRESH", usage: "age of the kanji list reloading it", value: func(c *Config) flag.Value { return (*durationValue)(&c.KanjidmgIndexRefresh) }},
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+const configFileEnv = "CONFIG_FILE"
+
+// LoadConfig layers the YAML config file, the env and then the flags in args over the defaults, and validates the result.
+// The config file is the -config flag or the CONFIG_FILE env, none by default.
+// PrintConfig is set by the -print-config flag.
+func LoadConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("omnikanji", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", os.Getenv(configFileEnv), "YAML config file (env "+configFileEnv+")")
+	fs.BoolVar(&printConfig, "print-config", false, "print the config and exit")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(&flagRecorder{name: s.env, isBool: isBoolValue(s.value(&Config{})), values: flagValues}, s.flag(), s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return nil, false, err
+	}
+	if fs.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg = DefaultConfig()
+	if *configFile != "" {
+		if err := loadConfigFile(cfg, *configFile); err != nil {
+			return nil, false, err
+		}
+		log.Printf("Config file %s loaded.", *configFile)
+	}
+
+	for _, s := range settings {
+		val := os.Getenv(s.env)
+		if val == "" {
+			continue
+		}
+		value := s.value(cfg)
+		if _, err := strconv.ParseBool(val); isBoolValue(value) && err != nil {
+			val = "true" // any value but false enables it, e.g. DEBUG=yes
+		}
+		if err := value.Set(val); err != nil {
+			return nil, false, fmt.Errorf("invalid %s=%s: %w", s.env, val, err)
+		}
+		s.log(s.env, value)
+	}
+
+	for _, s := range settings {
+		val, ok := flagValues[s.env]
+		if !ok {
+			continue
+		}
+		value := s.value(cfg)
+		if err := value.Set(val); err != nil {
+			return nil, false, fmt.Errorf("invalid -%s=%s: %w", s.flag(), val, err)
+		}
+		s.log("-"+s.flag(), value)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+func (s setting) log(name string, value flag.Value) {
+	if s.secret {
+		log.Printf("%s is set", name)
+		return
+	}
+	log.Printf("%s=%s", name, value)
+}
+
+// loadConfigFile decodes the YAML file over cfg. Unknown keys are errors, they are typos.
+func loadConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Dump is the config as YAML, loadable as a config file. Passwords are redacted.
+func (c *Config) Dump() ([]byte, error) {
+	dump := *c
+	dump.CacheRedisUrl = redactUrl(dump.CacheRedisUrl)
+	dump.UpstreamProxy = redactUrl(dump.UpstreamProxy)
+	return yaml.Marshal(&dump)
+}
+
+func redactUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// flagRecorder keeps the flag values until they are applied over the file and the env
+type flagRecorder struct {
+	name   string
+	isBool bool
+	values map[string]string
+}
+
+func (r *flagRecorder) String() string     { return "" }
+func (r *flagRecorder) IsBoolFlag() bool   { return r.isBool }
+func (r *flagRecorder) Set(v string) error { r.values[r.name] = v; return nil }
+
+func isBoolValue(v flag.Value) bool {
+	b, ok := v.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type boolValue bool
+
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	*v = boolValue(b)
+	return err
+}
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	*v = intValue(n)
+	return err
+}
+
+type floatValue float64
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	*v = floatValue(f)
+	return err
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	*v = durationValue(d)
+	return err
+}
+
+// listValue is separated by commas
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+// hostRatesValue is host=rate pairs separated by commas, e.g. jisho.org=2,www.kanjidamage.com=5
+type hostRatesValue map[string]float64
+
+func (v *hostRatesValue) String() string {
+	var pairs []string
+	for host, rate := range *v {
+		pairs = append(pairs, host+"="+strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *hostRatesValue) Set(s string) error {
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		host, rate, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || host == "" {
+			return errors.New("expected host=rate pairs")
+		}
+		f, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return err
+		}
+		rates[host] = f
+	}
+	*v = rates
+	return nil
+}
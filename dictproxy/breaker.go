@@ -0,0 +1,62 @@
+package dictproxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker stops requests to an upstream that keeps failing. After Failures consecutive failures it opens:
+// the requests are skipped for Cooldown, and then a single request probes whether the upstream is back.
+type Breaker struct {
+	Failures int
+	Cooldown time.Duration
+
+	mu        sync.Mutex
+	failed    int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker never opens for zero or less failures
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{Failures: failures, Cooldown: cooldown}
+}
+
+// Allow tells whether a request may be made. Each allowed request must be followed by Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Failures <= 0 || b.failed < b.Failures {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed = 0
+	b.probing = false
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed++
+	b.probing = false
+	if b.Failures > 0 && b.failed >= b.Failures {
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
+
+// Release ends an allowed request that tells nothing about the upstream, e.g. cancelled by the caller.
+// If it was the probe, the next request probes instead.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
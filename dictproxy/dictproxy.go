@@ -1,7 +1,14 @@
 package dictproxy
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 type HttpClient interface {
-	Get(url string) (*http.Response, error) 
-}
\ No newline at end of file
+	Get(ctx context.Context, url string) (*http.Response, error)
+}
+
+// ParserVersion is part of the cache keys of the parsed sections.
+// Bump it whenever the parsing changes, so that sections cached by older versions are not served.
+const ParserVersion = 3
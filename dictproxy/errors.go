@@ -0,0 +1,146 @@
+package dictproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+const (
+	SourceJisho    = "Jisho"
+	SourceKanjidmg = "Kanjidamage"
+)
+
+// Sentinels to check the kind of source error with errors.Is
+var (
+	ErrNotFound = errors.New("not found")
+	ErrUpstream = errors.New("upstream error")
+	ErrTimeout  = errors.New("timeout")
+	ErrParse    = errors.New("parse failure")
+	// ErrUnavailable is a source skipped after repeated failures
+	ErrUnavailable = errors.New("temporarily unavailable")
+)
+
+// NotFoundError is returned when the source does not know the searched word.
+type NotFoundError struct {
+	Source string
+	Query  string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s not found", e.Source, e.Query)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// UpstreamError is returned when the source could not be reached or responded with an unexpected http status.
+// StatusCode is 0 when there was no response at all.
+type UpstreamError struct {
+	Source     string
+	StatusCode int
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: upstream status %d: %s", e.Source, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s: upstream: %s", e.Source, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpstreamError) Is(target error) bool {
+	return target == ErrUpstream
+}
+
+// TimeoutError is returned when the source did not answer before the deadline.
+type TimeoutError struct {
+	Source string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timeout: %s", e.Source, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// UnavailableError is returned when the source keeps failing, so it is not requested for a while.
+type UnavailableError struct {
+	Source string
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: temporarily unavailable", e.Source)
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// ParseError is returned when the page of the source does not look like expected.
+type ParseError struct {
+	Source string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: parsing: %s", e.Source, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrParse
+}
+
+// requestError classifies an error returned by HttpClient.Get.
+func requestError(source string, resp *http.Response, err error) error {
+	if errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	if isTimeout(err) {
+		return &TimeoutError{Source: source, Err: err}
+	}
+
+	if resp != nil {
+		return &UpstreamError{Source: source, StatusCode: resp.StatusCode, Err: err}
+	}
+	return &UpstreamError{Source: source, Err: err}
+}
+
+// parseError wraps err into a ParseError, unless it already is a request error.
+// Parsing some pages needs additional requests (e.g. for images), and reading the page itself can time out.
+func parseError(source string, err error) error {
+	if isTimeout(err) && !errors.Is(err, ErrTimeout) {
+		return &TimeoutError{Source: source, Err: err}
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrUpstream) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	return &ParseError{Source: source, Err: err}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,100 @@
+package dictproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	omnihttp "github.com/zemiret/omnikanji/pkg/http"
+)
+
+// Failover is the HttpClient of a source with a circuit breaker, failing over to the mirrors of the source.
+// Requests to the base url are made to the first mirror whose breaker is closed, with the base url replaced,
+// e.g. https://web.archive.org/web/2023id_/http://www.kanjidamage.com for http://www.kanjidamage.com.
+// When every breaker is open, the request fails right away with an UnavailableError.
+type Failover struct {
+	source     string
+	httpClient HttpClient
+	mirrors    []*mirror // the base url first
+}
+
+type mirror struct {
+	baseUrl string
+	breaker *Breaker
+}
+
+// NewFailover gives baseUrl and each of the mirrors a breaker, see NewBreaker
+func NewFailover(source string, httpClient HttpClient, baseUrl string, mirrors []string, failures int, cooldown time.Duration) *Failover {
+	f := &Failover{source: source, httpClient: httpClient}
+	for _, baseUrl := range append([]string{baseUrl}, mirrors...) {
+		f.mirrors = append(f.mirrors, &mirror{baseUrl: strings.TrimSuffix(baseUrl, "/"), breaker: NewBreaker(failures, cooldown)})
+	}
+	return f
+}
+
+func (f *Failover) Get(ctx context.Context, url string) (resp *http.Response, err error) {
+	baseUrl := f.mirrors[0].baseUrl
+	if !strings.HasPrefix(url, baseUrl) {
+		return f.httpClient.Get(ctx, url)
+	}
+	path := strings.TrimPrefix(url, baseUrl)
+
+	tried := false
+	for _, m := range f.mirrors {
+		if !m.breaker.Allow() {
+			continue
+		}
+		if resp != nil {
+			resp.Body.Close() // of the previous mirror
+		}
+		tried = true
+
+		resp, err = f.httpClient.Get(ctx, m.baseUrl+path)
+		switch requestVerdict(ctx, resp, err) {
+		case verdictUp:
+			m.breaker.Success()
+			return resp, err
+		case verdictNone:
+			m.breaker.Release()
+			return resp, err
+		}
+		m.breaker.Failure()
+		if ctx.Err() != nil {
+			return resp, err
+		}
+	}
+	if !tried {
+		return nil, &UnavailableError{Source: f.source}
+	}
+	return resp, err
+}
+
+type verdict int
+
+const (
+	verdictUp verdict = iota
+	verdictDown
+	verdictNone
+)
+
+// requestVerdict tells what the request says about the upstream. It is down for throttling, server errors,
+// network errors and timeouts while the request was in flight. Requests that were not sent, e.g. that timed out
+// waiting for their turn, and the ones cancelled by the caller say nothing. Other errors are of what was asked for.
+func requestVerdict(ctx context.Context, resp *http.Response, err error) verdict {
+	if err == nil {
+		return verdictUp
+	}
+	if resp != nil {
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return verdictDown
+		}
+		return verdictUp
+	}
+	var waitErr *omnihttp.WaitError
+	if errors.As(err, &waitErr) || errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+		return verdictNone
+	}
+	return verdictDown
+}
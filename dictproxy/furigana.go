@@ -0,0 +1,61 @@
+package dictproxy
+
+import (
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/jptext"
+)
+
+// furiganaSlot is the furigana over a number of characters of the word.
+// Jisho renders a span per character (empty for kana and for the kanjis of a reading spanning several of them),
+// or a ruby over a whole run of kanjis.
+type furiganaSlot struct {
+	size    int
+	reading string
+}
+
+// alignFurigana splits the word into kanji parts with their readings and kana parts.
+// It returns nil if the readings cannot be matched with the word.
+func alignFurigana(word string, slots []furiganaSlot) []omnikanji.JishoWordPart {
+	runes := []rune(word)
+
+	total := 0
+	hasReading := false
+	for _, slot := range slots {
+		total += slot.size
+		hasReading = hasReading || slot.reading != ""
+	}
+	if total != len(runes) {
+		return nil
+	}
+
+	// Kana-only words have no furigana at all, kanji words without it are unknown
+	if !hasReading {
+		if jptext.KanjisCountInAWord(word) > 0 {
+			return nil
+		}
+		return []omnikanji.JishoWordPart{{MainText: word}}
+	}
+
+	var parts []omnikanji.JishoWordPart
+	idx := 0
+	for _, slot := range slots {
+		text := string(runes[idx : idx+slot.size])
+		idx += slot.size
+		last := len(parts) - 1
+
+		switch {
+		case slot.reading != "":
+			// A ruby over several kanjis is kept whole, it is not known which kana are whose, e.g. 明日 read あす
+			parts = append(parts, omnikanji.JishoWordPart{MainText: text, Reading: slot.reading})
+		case jptext.IsKanjiWord(text) && last >= 0 && parts[last].Reading != "":
+			// Kanji without own furigana belongs to the reading before it (jukujikun), e.g. 何処 read どこ
+			parts[last].MainText += text
+		case last >= 0 && parts[last].Reading == "":
+			parts[last].MainText += text
+		default:
+			parts = append(parts, omnikanji.JishoWordPart{MainText: text})
+		}
+	}
+
+	return parts
+}
@@ -1,9 +1,14 @@
 package dictproxy
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/zemiret/omnikanji"
@@ -22,35 +27,48 @@ func NewJisho(jishoSearchUrl string, httpClient HttpClient) *Jisho {
 	}
 }
 
-func (h *Jisho) Get(word string) (*omnikanji.JishoSection, error) {
-	url := h.Url(word)
+// Get looks up the word at jisho. Page is the 1-based page of the word results.
+func (h *Jisho) Get(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error) {
+	if page < 1 {
+		page = 1
+	}
+	url := h.PageUrl(word, page)
 
-	resp, err := h.httpClient.Get(url)
+	resp, err := h.httpClient.Get(ctx, url)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return nil, fmt.Errorf("request: %w", err)
+		return nil, requestError(SourceJisho, resp, err)
 	}
 	sect, err := h.parseResponse(resp)
 	if err != nil {
-		return nil, fmt.Errorf("parsing: %w", err)
+		return nil, parseError(SourceJisho, err)
 	}
 	if sect == nil {
-		return nil, nil
+		return nil, &NotFoundError{Source: SourceJisho, Query: word}
 	}
 	sect.Link = url
+	sect.Page = page
 
 	// There are some jisho words that do not split niceliy into parts. Fallback
-	if sect.WordSection.FullWord == "" && jptext.IsJapaneseWord(word) {
-		sect.WordSection.FullWord = word
+	if firstWord := sect.FirstWord(); firstWord.FullWord == "" && jptext.IsJapaneseWord(word) {
+		firstWord.FullWord = word
 	}
 
 	return sect, nil
 }
 
 func (h *Jisho) Url(word string) string {
-	return h.searchUrl + word
+	return h.searchUrl + url.PathEscape(word)
+}
+
+// PageUrl is the url of the given page of word results. The first page is the regular search.
+func (h *Jisho) PageUrl(word string, page int) string {
+	if page <= 1 {
+		return h.Url(word)
+	}
+	return fmt.Sprintf("%s%%20%%23words?page=%d", h.Url(word), page)
 }
 
 func (h *Jisho) parseResponse(resp *http.Response) (*omnikanji.JishoSection, error) {
@@ -59,104 +77,103 @@ func (h *Jisho) parseResponse(resp *http.Response) (*omnikanji.JishoSection, err
 		return nil, err
 	}
 
-	wordSection := h.parseWordSection(doc)
-	if wordSection == nil {
+	words := h.parseWords(doc)
+	if len(words) == 0 {
 		return nil, nil
 	}
 
 	kanjis := h.parseKanjiSection(doc)
 
 	return &omnikanji.JishoSection{
-		WordSection: *wordSection,
-		Kanjis:      kanjis,
+		Words:   words,
+		Kanjis:  kanjis,
+		HasMore: h.parseHasMoreWords(doc),
 	}, nil
 }
 
-func (h *Jisho) parseWordSection(doc *goquery.Document) *omnikanji.JishoWordSection {
+func (h *Jisho) parseWords(doc *goquery.Document) []omnikanji.JishoWordSection {
+	var words []omnikanji.JishoWordSection
+
+	// #secondary has names, that are also .concept_light
+	doc.Find("#primary .concept_light").Each(func(_ int, el *goquery.Selection) {
+		words = append(words, h.parseWordSection(el))
+	})
+
+	return words
+}
+
+func (h *Jisho) parseWordSection(wordSectionEl *goquery.Selection) omnikanji.JishoWordSection {
 	var wordSection omnikanji.JishoWordSection
 
-	wordSectionEl := doc.Find(".concept_light").First()
-	if wordSectionEl.Length() == 0 {
-		return nil
-	}
 	readingsSection := wordSectionEl.Find(".concept_light-wrapper .concept_light-readings").First()
 	meaningSection := wordSectionEl.Find(".meanings-wrapper").First()
 
+	wordSection.Link = absoluteUrl(h.searchUrl, wordSectionEl.Find(".light-details_link").AttrOr("href", ""))
 	wordSection.FullWord, wordSection.Parts = h.parseWordParts(readingsSection)
+	wordSection.SearchUrl = internalUrl(wordSection.Link, h.searchWord)
+	if wordSection.SearchUrl == "" && wordSection.FullWord != "" {
+		wordSection.SearchUrl = omnikanji.SearchUrl(wordSection.FullWord)
+	}
 	wordSection.Meanings = h.parseMeanings(meaningSection)
+	wordSection.Notes = h.parseNotes(meaningSection)
+	wordSection.OtherForms = h.parseOtherForms(meaningSection)
+	h.parseWordTags(wordSectionEl.Find(".concept_light-status").First(), &wordSection)
 
-	return &wordSection
+	return wordSection
 }
 
-func (h *Jisho) parseWordParts(wordSection *goquery.Selection) (string, []omnikanji.JishoWordPart) {
-	fullWord := h.parseWord(wordSection)
-	var furiganaInParts []string
-	wordSection.Find(".furigana .kanji").Each(func(_ int, el *goquery.Selection) {
-		furiganaInParts = append(furiganaInParts, el.Text())
-	})
-
-	kanjisCountInWord := jptext.KanjisCountInAWord(fullWord)
-
-	// Some words on jisho use different html structure. Fallback for such cases
-	if len(furiganaInParts) != kanjisCountInWord {
-		// TODO: sth is messed up :/ Some fallback in such cases
-		// TODO: Try "ruby" parsing. If that still fails, return none
-		furiganaInParts = []string{}
-
-		furiganaRuby := wordSection.Find(".furigana ruby")
-		furiganaRbStr := furiganaRuby.Find("rb").Text()
-		furiganaRtStr := furiganaRuby.Find("rt").Text()
-
-		if len(furiganaRtStr) == 0 {
-			return fullWord, nil
+func (h *Jisho) parseWordTags(statusSection *goquery.Selection, wordSection *omnikanji.JishoWordSection) {
+	statusSection.Find(".concept_light-tag").Each(func(_ int, el *goquery.Selection) {
+		tag := strings.TrimSpace(el.Text())
+		if tag == "" {
+			return
 		}
-
-		// each kanji has 1 kana to it
-		if len(furiganaRbStr) == len(furiganaRtStr) {
-			//var wordParts []omnikanji.JishoWordPart
-			//for i, rbKanji := range furiganaRbStr {
-			//	//				rtReading :=
-			//}
-		} else {
-			// Dunno how I could figure out which kana belongs to which kanji
-			return fullWord, nil
+		wordSection.Tags = append(wordSection.Tags, tag)
+
+		if el.HasClass("concept_light-common") {
+			wordSection.Common = true
+		} else if level, ok := parseTagLevel(tag, "JLPT N"); ok {
+			wordSection.JLPT = level
+		} else if level, ok := parseTagLevel(tag, "Wanikani level "); ok {
+			wordSection.WaniKani = level
 		}
+	})
+}
 
-		if len(furiganaInParts) != kanjisCountInWord {
-			return fullWord, nil
-		}
+// parseTagLevel parses the number of tags like "JLPT N5"
+func parseTagLevel(tag string, prefix string) (int, bool) {
+	if !strings.HasPrefix(tag, prefix) {
+		return 0, false
 	}
+	level, err := strconv.Atoi(strings.TrimPrefix(tag, prefix))
+	if err != nil {
+		return 0, false
+	}
+	return level, true
+}
 
-	var wordParts []omnikanji.JishoWordPart
-	kanaPart := ""
-	kanjiIdx := 0
+func (h *Jisho) parseHasMoreWords(doc *goquery.Document) bool {
+	return doc.Find("#primary a.more").FilterFunction(func(_ int, el *goquery.Selection) bool {
+		return strings.Contains(el.AttrOr("href", ""), "%23words?page=")
+	}).Length() > 0
+}
 
-	for _, c := range fullWord {
-		if jptext.IsKanji(c) {
-			if kanaPart != "" {
-				wordParts = append(wordParts, omnikanji.JishoWordPart{
-					MainText: kanaPart,
-				})
-				kanaPart = ""
-			}
+func (h *Jisho) parseWordParts(wordSection *goquery.Selection) (string, []omnikanji.JishoWordPart) {
+	fullWord := h.parseWord(wordSection)
 
-			wordParts = append(wordParts, omnikanji.JishoWordPart{
-				MainText: string(c),
-				Reading:  furiganaInParts[kanjiIdx],
+	var slots []furiganaSlot
+	wordSection.Find(".furigana").First().Children().Each(func(_ int, el *goquery.Selection) {
+		if goquery.NodeName(el) == "ruby" {
+			slots = append(slots, furiganaSlot{
+				size:    utf8.RuneCountInString(strings.TrimSpace(el.Find("rb").Text())),
+				reading: strings.TrimSpace(el.Find("rt").Text()),
 			})
-			kanjiIdx++
-		} else {
-			kanaPart += string(c)
+			return
 		}
-	}
-
-	if kanaPart != "" {
-		wordParts = append(wordParts, omnikanji.JishoWordPart{
-			MainText: kanaPart,
-		})
-	}
+		slots = append(slots, furiganaSlot{size: 1, reading: strings.TrimSpace(el.Text())})
+	})
 
-	return fullWord, wordParts
+	return fullWord, alignFurigana(fullWord, slots)
 }
 
 func (h *Jisho) parseWord(readingsSection *goquery.Selection) string {
@@ -183,10 +200,7 @@ func (h *Jisho) parseKanjis(kanjiSection *goquery.Selection) []omnikanji.JishoKa
 		onyomisEl.Find(".type").Remove()
 
 		kanjis = append(kanjis, omnikanji.JishoKanji{
-			Kanji: omnikanji.JishoWordWithLink{
-				Link: kanjiLink.AttrOr("href", ""),
-				Word: kanjiLink.Text(),
-			},
+			Kanji:    h.link(kanjiLink.AttrOr("href", ""), kanjiLink.Text()),
 			Meaning:  el.Find(".meanings").Text(),
 			Kunyomis: h.parseKanjiReadings(kunyomisEl),
 			Onyomis:  h.parseKanjiReadings(onyomisEl),
@@ -199,10 +213,7 @@ func (h *Jisho) parseKanjis(kanjiSection *goquery.Selection) []omnikanji.JishoKa
 func (h *Jisho) parseKanjiReadings(readingsEl *goquery.Selection) []omnikanji.JishoWordWithLink {
 	var readings []omnikanji.JishoWordWithLink
 	readingsEl.Find("a").Each(func(_ int, el *goquery.Selection) {
-		readings = append(readings, omnikanji.JishoWordWithLink{
-			Link: el.AttrOr("href", ""),
-			Word: el.Text(),
-		})
+		readings = append(readings, h.link(el.AttrOr("href", ""), el.Text()))
 	})
 
 	return readings
@@ -217,12 +228,21 @@ func (h *Jisho) parseMeanings(meaningSection *goquery.Selection) []omnikanji.Jis
 		if el.HasClass("meaning-tags") {
 			lastTag = strings.TrimSpace(el.Text())
 		} else if el.HasClass("meaning-wrapper") {
+			// Notes and other forms of the word are in meaning blocks of their own, they are not meanings
+			if lastTag == jishoOtherFormsTag || el.Find(".meaning-representation_notes").Length() > 0 {
+				lastTag = ""
+				return
+			}
+
 			var jishoMeaning omnikanji.JishoMeaning
-			jishoMeaning.Meaning = strings.TrimSpace(el.Find(".meaning-meaning").Text())
+			jishoMeaning.Meaning = h.parseRichText(el.Find(".meaning-meaning"))
+			jishoMeaning.Abstract = h.parseRichText(el.Find(".meaning-abstract"))
 			if lastTag != "" {
 				t := lastTag
 				jishoMeaning.Tags = &t
 			}
+			jishoMeaning.Sentences = h.parseSentences(el)
+			h.parseSupplementalInfo(el.Find(".supplemental_info"), &jishoMeaning)
 			jishoMeaning.ListIdx = idx
 			idx++
 			meanings = append(meanings, jishoMeaning)
@@ -231,3 +251,167 @@ func (h *Jisho) parseMeanings(meaningSection *goquery.Selection) []omnikanji.Jis
 	})
 	return meanings
 }
+
+func (h *Jisho) parseRichText(sel *goquery.Selection) omnikanji.RichText {
+	return parseRichText(sel, h.searchUrl, h.searchWord)
+}
+
+var (
+	jishoWordIdRe    = regexp.MustCompile(`^[0-9a-f]{24}$`)
+	jishoWordIndexRe = regexp.MustCompile(`-\d+$`) // homographs are told apart by an index, e.g. /word/何-2
+)
+
+// searchWord is the word of a jisho word or search link, e.g. 運転免許 for //jisho.org/word/%E9%81%8B%E8%BB%A2%E5%85%8D%E8%A8%B1.
+// Jisho search tags like #kanji and homograph indexes are dropped. Words linked by their jisho id can't be searched.
+func (h *Jisho) searchWord(link *url.URL) string {
+	if !strings.HasSuffix(link.Hostname(), "jisho.org") {
+		return ""
+	}
+	for _, prefix := range []string{"/word/", "/search/"} {
+		query := strings.TrimPrefix(link.Path, prefix)
+		if query == link.Path || jishoWordIdRe.MatchString(query) {
+			continue
+		}
+		if prefix == "/word/" {
+			query = jishoWordIndexRe.ReplaceAllString(query, "")
+		}
+		var words []string
+		for _, w := range strings.Fields(query) {
+			if !strings.HasPrefix(w, "#") {
+				words = append(words, w)
+			}
+		}
+		return strings.Join(words, " ")
+	}
+	return ""
+}
+
+// link is the absolute upstream link scraped from a jisho page, with the omnikanji search of the same word
+func (h *Jisho) link(href string, word string) omnikanji.JishoWordWithLink {
+	link := absoluteUrl(h.searchUrl, href)
+	return omnikanji.JishoWordWithLink{
+		Link:      link,
+		SearchUrl: internalUrl(link, h.searchWord),
+		Word:      word,
+	}
+}
+
+func (h *Jisho) parseSupplementalInfo(infoSection *goquery.Selection, meaning *omnikanji.JishoMeaning) {
+	infoSection.Find(".sense-tag").Each(func(_ int, el *goquery.Selection) {
+		switch {
+		case el.HasClass("tag-see_also"):
+			el.Find("a").Each(func(_ int, a *goquery.Selection) {
+				word := strings.TrimSpace(a.Text())
+				seeAlso := h.link(a.AttrOr("href", ""), word)
+				seeAlso.SearchUrl = omnikanji.SearchUrl(word)
+				meaning.SeeAlso = append(meaning.SeeAlso, seeAlso)
+			})
+		case el.HasClass("tag-info"):
+			meaning.Info = append(meaning.Info, strings.TrimSpace(el.Text()))
+		default:
+			meaning.SupplementalInfo = append(meaning.SupplementalInfo, strings.TrimSpace(el.Text()))
+		}
+	})
+}
+
+const jishoOtherFormsTag = "Other forms"
+
+func (h *Jisho) parseOtherForms(meaningSection *goquery.Selection) []omnikanji.JishoOtherForm {
+	var forms []omnikanji.JishoOtherForm
+
+	meaningSection.Children().Filter(".meaning-tags").Each(func(_ int, el *goquery.Selection) {
+		if strings.TrimSpace(el.Text()) != jishoOtherFormsTag {
+			return
+		}
+
+		// Forms look like "矢張 【やはり】", kana-only forms have no reading
+		el.Next().Find(".break-unit").Each(func(_ int, formEl *goquery.Selection) {
+			word, reading, _ := strings.Cut(formEl.Text(), "【")
+			word = strings.TrimSpace(word)
+			forms = append(forms, omnikanji.JishoOtherForm{
+				Word:    word,
+				Reading: strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(reading), "】")),
+				Link:    omnikanji.SearchUrl(word),
+			})
+		})
+	})
+
+	return forms
+}
+
+func (h *Jisho) parseNotes(meaningSection *goquery.Selection) *string {
+	notes := strings.TrimSpace(meaningSection.Find(".meaning-representation_notes").Text())
+	if notes == "" {
+		return nil
+	}
+	return &notes
+}
+
+func (h *Jisho) parseSentences(meaningEl *goquery.Selection) []omnikanji.JishoSentence {
+	var sentences []omnikanji.JishoSentence
+
+	meaningEl.Find(".sentences .sentence").Each(func(_ int, el *goquery.Selection) {
+		var parts []omnikanji.JishoWordPart
+
+		// Punctuation and some words are bare text nodes between the list items
+		el.Find("ul.japanese").First().Contents().Each(func(_ int, c *goquery.Selection) {
+			if goquery.NodeName(c) == "#text" {
+				parts = appendSentenceParts(parts, omnikanji.JishoWordPart{MainText: strings.TrimSpace(c.Text())})
+				return
+			}
+			if c.HasClass("english") {
+				return
+			}
+
+			text := strings.TrimSpace(c.Find(".unlinked").Text())
+			reading := strings.TrimSpace(c.Find(".furigana").Text())
+			parts = appendSentenceParts(parts, splitOkurigana(text, reading)...)
+		})
+
+		sentences = append(sentences, omnikanji.JishoSentence{
+			Parts:       parts,
+			Translation: strings.TrimSpace(el.Find(".english").Text()),
+		})
+	})
+
+	return sentences
+}
+
+// splitOkurigana separates the kana around the kanjis of a sentence word, as its reading belongs to the kanjis only.
+// E.g. 比べたり read くら is split into 比 (くら) and べたり.
+func splitOkurigana(text string, reading string) []omnikanji.JishoWordPart {
+	runes := []rune(text)
+	first, last := -1, -1
+	for i, r := range runes {
+		if jptext.IsKanji(r) {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if reading == "" || first == -1 {
+		return []omnikanji.JishoWordPart{{MainText: text, Reading: reading}}
+	}
+
+	return []omnikanji.JishoWordPart{
+		{MainText: string(runes[:first])},
+		{MainText: string(runes[first : last+1]), Reading: reading},
+		{MainText: string(runes[last+1:])},
+	}
+}
+
+// appendSentenceParts skips empty parts and joins the parts without reading with each other
+func appendSentenceParts(parts []omnikanji.JishoWordPart, newParts ...omnikanji.JishoWordPart) []omnikanji.JishoWordPart {
+	for _, p := range newParts {
+		if p.MainText == "" {
+			continue
+		}
+		if n := len(parts); n > 0 && p.Reading == "" && parts[n-1].Reading == "" {
+			parts[n-1].MainText += p.MainText
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return parts
+}
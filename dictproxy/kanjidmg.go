@@ -1,23 +1,25 @@
 package dictproxy
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
+	"net/url"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/jptext"
 	"github.com/zemiret/omnikanji/pkg/ptr"
 )
 
-var (
-	KanjidmgNoKanjiErr = fmt.Errorf("kanji not found at kanjidamage")
-)
-
-func LoadKanjidmgLinks(httpClient HttpClient) (map[string]string, error) {
-	resp, err := httpClient.Get(omnikanji.KanjidmgListUrl)
+// LoadKanjidmgLinks loads the kanji list of the kanjidamage at baseUrl, e.g. omnikanji.KanjidmgBaseUrl
+func LoadKanjidmgLinks(ctx context.Context, baseUrl string, httpClient HttpClient) (map[string]string, error) {
+	resp, err := httpClient.Get(ctx, strings.TrimSuffix(baseUrl, "/")+"/kanji")
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -36,7 +38,7 @@ func LoadKanjidmgLinks(httpClient HttpClient) (map[string]string, error) {
 		link := el.Find("td:nth-child(3) a").First()
 
 		if href, ok := link.Attr("href"); ok {
-			links[link.Text()] = omnikanji.KanjidmgBaseUrl + href
+			links[link.Text()] = absoluteUrl(baseUrl, href)
 		}
 	})
 
@@ -44,49 +46,63 @@ func LoadKanjidmgLinks(httpClient HttpClient) (map[string]string, error) {
 }
 
 type Kanjidmg struct {
-	links      map[string]string
+	baseUrl    string
+	index      atomic.Value // KanjidmgIndex
 	httpClient HttpClient
 }
 
-func NewKanjidmg(links map[string]string, httpClient HttpClient) *Kanjidmg {
-	return &Kanjidmg{
-		links:      links,
+// NewKanjidmg looks the kanjis up with the links. Without links, the index is loaded later,
+// see LoadIndexSnapshot and KeepIndexFresh.
+func NewKanjidmg(baseUrl string, links map[string]string, httpClient HttpClient) *Kanjidmg {
+	h := &Kanjidmg{
+		baseUrl:    baseUrl,
 		httpClient: httpClient,
 	}
+	if links != nil {
+		h.SetIndex(KanjidmgIndex{Links: links, Updated: time.Now()})
+	}
+	return h
 }
 
 func (h *Kanjidmg) Url(kanji string) string {
-	return h.links[kanji]
+	return h.Index().Links[kanji]
 }
 
-func (h *Kanjidmg) Get(kanji rune) (*omnikanji.KanjidmgSection, error) {
-	url := h.Url(string(kanji))
+func (h *Kanjidmg) Get(ctx context.Context, kanji rune) (*omnikanji.KanjidmgSection, error) {
+	links := h.Index().Links
+	if len(links) == 0 {
+		return nil, &UnavailableError{Source: SourceKanjidmg} // the index is not loaded yet
+	}
+	url := links[string(kanji)]
 	if url == "" {
-		return nil, KanjidmgNoKanjiErr
+		return nil, &NotFoundError{Source: SourceKanjidmg, Query: string(kanji)}
 	}
 
-	resp, err := h.httpClient.Get(url)
-	if resp != nil {
-		defer resp.Body.Close()
+	resp, err := h.httpClient.Get(ctx, url)
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, requestError(SourceKanjidmg, resp, err)
 	}
+
+	// The page is read whole and closed before parsing. Until it is closed it holds its slot of the client,
+	// which the kanji images fetched while parsing need.
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		return nil, fmt.Errorf("request: %w", err)
+		return nil, parseError(SourceKanjidmg, err)
 	}
 
-	sect, err := h.parseResponse(resp, url)
+	sect, err := h.parseDocument(ctx, doc, url)
 	if err != nil {
-		return nil, fmt.Errorf("parsing: %w", err)
+		return nil, parseError(SourceKanjidmg, err)
 	}
 
 	return sect, nil
 }
 
-func (h *Kanjidmg) parseResponse(resp *http.Response, url string) (*omnikanji.KanjidmgSection, error) {
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
+func (h *Kanjidmg) parseDocument(ctx context.Context, doc *goquery.Document, url string) (*omnikanji.KanjidmgSection, error) {
 	sect := &omnikanji.KanjidmgSection{}
 
 	rows := doc.Find(".container").Last().Find(".row")
@@ -94,37 +110,50 @@ func (h *Kanjidmg) parseResponse(resp *http.Response, url string) (*omnikanji.Ka
 	wordSectionClone := wordSection.Clone()
 	wordSectionClone.Find("h1").Remove()
 	radicalsSection := wordSectionClone.Find(".col-md-8")
-	contentSection := rows.Eq(2)
+	contentSection := rows.Slice(2, goquery.ToEnd) // some sections, like synonyms, are in rows of their own
 
-	// TODO: Top comment
-	parsedWordSection, err := h.buildWordSection(wordSection, url)
+	parsedWordSection, err := h.buildWordSection(ctx, wordSection, url)
 	if err != nil {
 		return nil, err
 	}
 	sect.WordSection = *parsedWordSection
-	parsedRadicalsSection, err := h.parseRadicals(radicalsSection)
+	parsedRadicalsSection, err := h.parseRadicals(ctx, radicalsSection)
 	if err != nil {
 		return nil, err
 	}
 	sect.Radicals = parsedRadicalsSection
 	sect.Onyomi = ptr.String(h.parseOnyomi(contentSection))
-	sect.Mnemonic = ptr.String(h.parseMnemonic(contentSection))
+	sect.Mnemonic = h.parseMnemonic(contentSection)
+	sect.Kunyomi = h.parseKunyomi(contentSection)
+	sect.Jukugo = h.parseJukugo(contentSection)
+	sect.TopComment = h.parseTopComment(contentSection)
+	sect.Synonyms = h.parseSynonyms(contentSection)
+
+	if sect.UsedIn, err = h.parseUsedIn(ctx, contentSection); err != nil {
+		return nil, err
+	}
+	if sect.Lookalikes, err = h.parseKanjiTable(ctx, contentSection, "Lookalikes"); err != nil {
+		return nil, err
+	}
+	if sect.Mutants, err = h.parseKanjiTable(ctx, contentSection, "Mutants"); err != nil {
+		return nil, err
+	}
 
 	return sect, nil
 }
 
-func (h *Kanjidmg) buildWordSection(wordSection *goquery.Selection, url string) (*omnikanji.KanjidmgKanji, error) {
-	res, err := h.parseWordSection(wordSection)
+func (h *Kanjidmg) buildWordSection(ctx context.Context, wordSection *goquery.Selection, url string) (*omnikanji.KanjidmgKanji, error) {
+	res, err := h.parseWordSection(ctx, wordSection)
 	if err != nil {
 		return nil, err
 	}
-	res.Link = url
+	res.Link = absoluteUrl(h.baseUrl, url)
 	return res, nil
 }
 
-func (h *Kanjidmg) parseWordSection(wordSection *goquery.Selection) (*omnikanji.KanjidmgKanji, error) {
+func (h *Kanjidmg) parseWordSection(ctx context.Context, wordSection *goquery.Selection) (*omnikanji.KanjidmgKanji, error) {
 	kanjiCharSection := wordSection.Find("h1 .kanji_character")
-	kanjiStr, kanjiImg, err := h.kanjiTextOrImage(kanjiCharSection)
+	kanjiStr, kanjiImg, err := h.kanjiTextOrImage(ctx, kanjiCharSection)
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +166,7 @@ func (h *Kanjidmg) parseWordSection(wordSection *goquery.Selection) (*omnikanji.
 
 }
 
-func (h *Kanjidmg) kanjiTextOrImage(kanjiCharSection *goquery.Selection) (*string, *string, error) {
+func (h *Kanjidmg) kanjiTextOrImage(ctx context.Context, kanjiCharSection *goquery.Selection) (*string, *string, error) {
 	var kanjiStr, kanjiImg string
 	var err error
 
@@ -148,7 +177,7 @@ func (h *Kanjidmg) kanjiTextOrImage(kanjiCharSection *goquery.Selection) (*strin
 			return nil, nil, fmt.Errorf("cannot parse word section - there does not seem to be kanji in text nor in imagr")
 		}
 
-		kanjiImg, err = h.fetchKanjiImg(url)
+		kanjiImg, err = h.fetchKanjiImg(ctx, url)
 		if err != nil {
 			return nil, nil, fmt.Errorf("parseWordSection: %w", err)
 		}
@@ -157,50 +186,57 @@ func (h *Kanjidmg) kanjiTextOrImage(kanjiCharSection *goquery.Selection) (*strin
 	return ptr.String(kanjiStr), ptr.String(kanjiImg), nil
 }
 
-func (h *Kanjidmg) fetchKanjiImg(url string) (string, error) {
-	resp, err := h.httpClient.Get(omnikanji.KanjidmgBaseUrl + "/" + url)
+// maxKanjiImgSize bounds the kanji images inlined into the page. They are small glyphs, a few kB.
+const maxKanjiImgSize = 256 << 10
+
+func (h *Kanjidmg) fetchKanjiImg(ctx context.Context, url string) (string, error) {
+	resp, err := h.httpClient.Get(ctx, absoluteUrl(h.baseUrl, url))
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return "", fmt.Errorf("error fetching kanji image: %w", err)
+		return "", requestError(SourceKanjidmg, resp, fmt.Errorf("error fetching kanji image: %w", err))
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxKanjiImgSize+1))
 	if err != nil {
 		return "", fmt.Errorf("error reading kanji image response: %w", err)
 	}
+	if len(body) > maxKanjiImgSize {
+		return "", fmt.Errorf("kanji image larger than %d bytes", maxKanjiImgSize)
+	}
 
 	encodedImg := base64.StdEncoding.EncodeToString(body)
 	return encodedImg, nil
 }
 
-func (h *Kanjidmg) parseRadicals(radicalsSection *goquery.Selection) (radicals []omnikanji.KanjidmgKanji, err error) {
+func (h *Kanjidmg) parseRadicals(ctx context.Context, radicalsSection *goquery.Selection) (radicals []omnikanji.KanjidmgKanji, err error) {
 	radicalsSection.Find("h1").Remove()
 
 	radicalsLinks := radicalsSection.Find("a")
 
 	usedLinks := 0
-	radicalsSection.Contents().Not("a").Each(func(_ int, m *goquery.Selection) {
+	radicalsSection.Contents().Not("a").EachWithBreak(func(_ int, m *goquery.Selection) bool {
 		meaningText := h.trimNotCharacters(m.Text())
 		if strings.TrimSpace(meaningText) != "" {
 
 			kanjiCharSection := radicalsLinks.Eq(usedLinks)
-			kanjiStr, kanjiImg, err := h.kanjiTextOrImage(kanjiCharSection)
-			if err != nil {
+			kanjiStr, kanjiImg, kanjiErr := h.kanjiTextOrImage(ctx, kanjiCharSection)
+			if kanjiErr != nil {
 				radicals = nil
-				err = fmt.Errorf("parseRadicals: %w", err)
-				return
+				err = fmt.Errorf("parseRadicals: %w", kanjiErr)
+				return false
 			}
 
 			radicals = append(radicals, omnikanji.KanjidmgKanji{
 				Kanji:      kanjiStr,
 				KanjiImage: kanjiImg,
 				Meaning:    meaningText,
-				Link:       omnikanji.KanjidmgBaseUrl + "/" + radicalsLinks.Eq(usedLinks).AttrOr("href", ""),
+				Link:       absoluteUrl(h.baseUrl, radicalsLinks.Eq(usedLinks).AttrOr("href", "")),
 			})
 			usedLinks += 1
 		}
+		return true
 	})
 
 	return
@@ -214,8 +250,207 @@ func (h *Kanjidmg) parseOnyomi(contentSection *goquery.Selection) string {
 	return h.parseContentRow(contentSection, "Onyomi")
 }
 
-func (h *Kanjidmg) parseMnemonic(contentSection *goquery.Selection) string {
-	return h.parseContentRow(contentSection, "Mnemonic")
+func (h *Kanjidmg) parseMnemonic(contentSection *goquery.Selection) omnikanji.RichText {
+	return parseRichText(h.contentTable(contentSection, "Mnemonic").Find("td"), h.baseUrl, h.searchWord)
+}
+
+// searchWord is the kanji of a kanjidamage kanji page link, e.g. 荷 for /kanji/68-luggage-%E8%8D%B7
+func (h *Kanjidmg) searchWord(link *url.URL) string {
+	if !strings.HasSuffix(link.Hostname(), "kanjidamage.com") || !strings.HasPrefix(link.Path, "/kanji/") {
+		return ""
+	}
+	slug := strings.TrimPrefix(link.Path, "/kanji/")
+	kanji := slug[strings.LastIndex(slug, "-")+1:]
+	if kanji == "" || !jptext.IsJapaneseWord(kanji) {
+		return ""
+	}
+	return kanji
+}
+
+func (h *Kanjidmg) parseKunyomi(contentSection *goquery.Selection) []omnikanji.KanjidmgKunyomi {
+	var kunyomis []omnikanji.KanjidmgKunyomi
+
+	h.contentTable(contentSection, "Kunyomi").Find("tr").Each(func(_ int, row *goquery.Selection) {
+		var kunyomi omnikanji.KanjidmgKunyomi
+
+		// Particles are in parentheses around the reading, e.g. (を) か＊える
+		readingSeen := false
+		row.Find("td").First().Children().Each(func(_ int, el *goquery.Selection) {
+			switch {
+			case el.HasClass("kanji_character"):
+				kunyomi.Reading = strings.TrimSpace(el.Text())
+				readingSeen = true
+			case el.HasClass("particles") && !readingSeen:
+				kunyomi.ParticlesBefore = strings.TrimSpace(el.Text())
+			case el.HasClass("particles"):
+				kunyomi.ParticlesAfter = strings.TrimSpace(el.Text())
+			}
+		})
+		if kunyomi.Reading == "" {
+			return
+		}
+
+		meaningEl := row.Find("td").Eq(1)
+		kunyomi.Meaning = h.textBeforeBreak(meaningEl)
+		kunyomi.Usefulness = h.parseUsefulness(meaningEl)
+		kunyomis = append(kunyomis, kunyomi)
+	})
+
+	sort.SliceStable(kunyomis, func(i, j int) bool {
+		return kunyomis[i].Usefulness > kunyomis[j].Usefulness
+	})
+	return kunyomis
+}
+
+func (h *Kanjidmg) parseJukugo(contentSection *goquery.Selection) []omnikanji.KanjidmgJukugo {
+	var jukugos []omnikanji.KanjidmgJukugo
+
+	h.contentTable(contentSection, "Jukugo").Find("tr").Each(func(_ int, row *goquery.Selection) {
+		wordEl := row.Find("td").First()
+		rubyEl := wordEl.Find(".kanji_character ruby").First()
+		rubyClone := rubyEl.Clone()
+		rubyClone.Find("rp, rt").Remove()
+
+		word := strings.TrimSpace(rubyClone.Text())
+		if word == "" {
+			return
+		}
+
+		meaningEl := row.Find("td").Eq(1).Find("p").First()
+		jukugos = append(jukugos, omnikanji.KanjidmgJukugo{
+			Word:       word,
+			Reading:    strings.TrimSpace(rubyEl.Find("rt").Text()),
+			Particles:  strings.TrimSpace(wordEl.Find(".particles").Text()),
+			Meaning:    h.textBeforeBreak(meaningEl),
+			Usefulness: h.parseUsefulness(meaningEl),
+		})
+	})
+
+	sort.SliceStable(jukugos, func(i, j int) bool {
+		return jukugos[i].Usefulness > jukugos[j].Usefulness
+	})
+	return jukugos
+}
+
+// parseTopComment reads the paragraphs before the first section of the content
+func (h *Kanjidmg) parseTopComment(contentSection *goquery.Selection) *string {
+	var paragraphs []string
+	contentSection.Find(".col-md-12").First().Children().EachWithBreak(func(_ int, el *goquery.Selection) bool {
+		if goquery.NodeName(el) == "h2" {
+			return false
+		}
+		if goquery.NodeName(el) == "p" {
+			if text := strings.TrimSpace(el.Text()); text != "" {
+				paragraphs = append(paragraphs, text)
+			}
+		}
+		return true
+	})
+
+	if len(paragraphs) == 0 {
+		return nil
+	}
+	return ptr.String(strings.Join(paragraphs, "\n"))
+}
+
+func (h *Kanjidmg) parseUsedIn(ctx context.Context, contentSection *goquery.Selection) (kanjis []omnikanji.KanjidmgKanji, err error) {
+	links := contentSection.Find("h2:contains(\"Used In\")").First().NextFiltered("ul").Find("li a")
+	links.EachWithBreak(func(_ int, link *goquery.Selection) bool {
+		var kanji *omnikanji.KanjidmgKanji
+		if kanji, err = h.parseKanjiLink(ctx, link); err != nil {
+			kanjis = nil
+			err = fmt.Errorf("parseUsedIn: %w", err)
+			return false
+		}
+		kanjis = append(kanjis, *kanji)
+		return true
+	})
+
+	return
+}
+
+// parseKanjiTable reads tables with a kanji and its meaning in each row, like the lookalikes
+func (h *Kanjidmg) parseKanjiTable(ctx context.Context, contentSection *goquery.Selection, sectionHeader string) (kanjis []omnikanji.KanjidmgKanji, err error) {
+	h.contentTable(contentSection, sectionHeader).Find("tr").EachWithBreak(func(_ int, row *goquery.Selection) bool {
+		link := row.Find("td").First().Find("a").First()
+		if link.Length() == 0 {
+			return true // header row
+		}
+
+		var kanji *omnikanji.KanjidmgKanji
+		if kanji, err = h.parseKanjiLink(ctx, link); err != nil {
+			kanjis = nil
+			err = fmt.Errorf("parse %s: %w", sectionHeader, err)
+			return false
+		}
+		kanji.Meaning = strings.Join(strings.Fields(row.Find("td").Eq(1).Text()), " ")
+		kanjis = append(kanjis, *kanji)
+		return true
+	})
+
+	return
+}
+
+func (h *Kanjidmg) parseKanjiLink(ctx context.Context, link *goquery.Selection) (*omnikanji.KanjidmgKanji, error) {
+	kanjiStr, kanjiImg, err := h.kanjiTextOrImage(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	return &omnikanji.KanjidmgKanji{
+		Kanji:      kanjiStr,
+		KanjiImage: kanjiImg,
+		Link:       absoluteUrl(h.baseUrl, link.AttrOr("href", "")),
+	}, nil
+}
+
+// parseSynonyms reads the words of each synonym group, e.g. 運ぶ, 担う, 担ぐ for "carry"
+func (h *Kanjidmg) parseSynonyms(contentSection *goquery.Selection) []omnikanji.KanjidmgKanji {
+	var synonyms []omnikanji.KanjidmgKanji
+
+	h.contentTable(contentSection, "Synonyms").Find("td").Each(func(_ int, el *goquery.Selection) {
+		groupLink := el.Find("a").First()
+		group := strings.TrimSpace(groupLink.Text())
+
+		el.Contents().Each(func(_ int, c *goquery.Selection) {
+			if goquery.NodeName(c) != "#text" {
+				return
+			}
+			for _, word := range strings.Fields(c.Text()) {
+				synonyms = append(synonyms, omnikanji.KanjidmgKanji{
+					Kanji:   ptr.String(word),
+					Meaning: group,
+					Link:    absoluteUrl(h.baseUrl, groupLink.AttrOr("href", "")),
+				})
+			}
+		})
+	})
+
+	return synonyms
+}
+
+// contentTable is the table following the section header
+func (h *Kanjidmg) contentTable(section *goquery.Selection, sectionHeader string) *goquery.Selection {
+	return section.Find("h2:contains(" + sectionHeader + ")").First().NextFiltered("table")
+}
+
+// textBeforeBreak is the own text of el up to the first <br>, without the ratings and labels
+func (h *Kanjidmg) textBeforeBreak(el *goquery.Selection) string {
+	var text strings.Builder
+	el.Contents().EachWithBreak(func(_ int, c *goquery.Selection) bool {
+		if goquery.NodeName(c) == "br" {
+			return false
+		}
+		if goquery.NodeName(c) == "#text" {
+			text.WriteString(c.Text())
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func (h *Kanjidmg) parseUsefulness(el *goquery.Selection) omnikanji.Usefulness {
+	return omnikanji.Usefulness(strings.Count(el.Find(".usefulness-stars").First().Text(), "★"))
 }
 
 func (h *Kanjidmg) parseContentRow(section *goquery.Selection, sectionHeader string) string {
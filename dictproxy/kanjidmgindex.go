@@ -0,0 +1,130 @@
+package dictproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// KanjidmgIndex is the list of kanjidamage kanjis, with links to their pages
+type KanjidmgIndex struct {
+	Links   map[string]string `json:"links"`
+	Updated time.Time         `json:"updated"`
+}
+
+// kanjidmgIndexRetry is the wait after a failed refresh of the index
+const kanjidmgIndexRetry = time.Minute
+
+// Index is the index in use. Empty until one is loaded.
+func (h *Kanjidmg) Index() KanjidmgIndex {
+	if idx, ok := h.index.Load().(KanjidmgIndex); ok {
+		return idx
+	}
+	return KanjidmgIndex{}
+}
+
+// SetIndex swaps the index in, the lookups in progress finish with the previous one
+func (h *Kanjidmg) SetIndex(idx KanjidmgIndex) {
+	h.index.Store(idx)
+}
+
+// LoadIndexSnapshot sets the index saved by SaveIndexSnapshot
+func (h *Kanjidmg) LoadIndexSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var idx KanjidmgIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if len(idx.Links) == 0 {
+		return fmt.Errorf("%s has no kanjis", path)
+	}
+	h.SetIndex(idx)
+	return nil
+}
+
+// SaveIndexSnapshot writes the index to a temporary file first, so that a crash never leaves half of it
+func (h *Kanjidmg) SaveIndexSnapshot(path string) error {
+	data, err := json.Marshal(h.Index())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// RefreshIndex loads the index from kanjidamage and swaps it in. An empty list is an error,
+// e.g. a maintenance page, so that it does not replace a good index.
+func (h *Kanjidmg) RefreshIndex(ctx context.Context) error {
+	links, err := LoadKanjidmgLinks(ctx, h.baseUrl, h.httpClient)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		return errors.New("kanjidamage kanji list is empty")
+	}
+	h.SetIndex(KanjidmgIndex{Links: links, Updated: time.Now()})
+	return nil
+}
+
+// KeepIndexFresh refreshes the index once it is older than interval, until ctx is done.
+// Zero or less interval only loads the index when there is none.
+// Each refreshed index is saved to snapshotPath, if set. Failed refreshes are retried a minute later.
+func (h *Kanjidmg) KeepIndexFresh(ctx context.Context, interval time.Duration, snapshotPath string) {
+	for {
+		wait := interval - time.Since(h.Index().Updated)
+		if len(h.Index().Links) == 0 {
+			wait = 0
+		} else if interval <= 0 {
+			return
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		if err := h.RefreshIndex(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("error refreshing kanjidamage index, retrying in %s: %s", kanjidmgIndexRetry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(kanjidmgIndexRetry):
+			}
+			continue
+		}
+		log.Printf("kanjidamage index refreshed: %d kanjis", len(h.Index().Links))
+
+		if snapshotPath != "" {
+			if err := h.SaveIndexSnapshot(snapshotPath); err != nil {
+				log.Printf("error saving kanjidamage index to %s: %s", snapshotPath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package dictproxy
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/zemiret/omnikanji"
+)
+
+// richTextParser sanitises dictionary html into omnikanji.RichText.
+// Only the allow-listed formatting is kept. Other elements are unwrapped to their text,
+// and the ones that never hold readable text (scripts, forms...) are dropped with their content.
+type richTextParser struct {
+	baseUrl string
+	// searchWord is the word a dictionary link refers to, empty if it is not a link to a word
+	searchWord func(link *url.URL) string
+
+	runs omnikanji.RichText
+}
+
+func parseRichText(sel *goquery.Selection, baseUrl string, searchWord func(link *url.URL) string) omnikanji.RichText {
+	p := &richTextParser{baseUrl: baseUrl, searchWord: searchWord}
+
+	sel.Each(func(_ int, el *goquery.Selection) {
+		p.walk(el, nil, "")
+	})
+	p.trimEnd()
+	return p.runs
+}
+
+// richTextSpanStyles are the styles of the span classes, in the order they are applied
+var richTextSpanStyles = []omnikanji.RichTextStyle{
+	omnikanji.RichTextComponent,
+	omnikanji.RichTextTranslation,
+	omnikanji.RichTextOnyomi,
+	omnikanji.RichTextKunyomi,
+}
+
+func (p *richTextParser) walk(sel *goquery.Selection, styles []omnikanji.RichTextStyle, link string) {
+	sel.Contents().Each(func(_ int, c *goquery.Selection) {
+		switch goquery.NodeName(c) {
+		case "#text":
+			p.addText(c.Text(), styles, link)
+		case "br":
+			p.addBreak()
+		case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6":
+			p.addBreak()
+			p.walk(c, styles, link)
+			p.addBreak()
+		case "b", "strong":
+			p.walk(c, withRichTextStyle(styles, omnikanji.RichTextBold), link)
+		case "i", "em":
+			p.walk(c, withRichTextStyle(styles, omnikanji.RichTextItalic), link)
+		case "span":
+			spanStyles := styles
+			for _, style := range richTextSpanStyles {
+				if c.HasClass(string(style)) {
+					spanStyles = withRichTextStyle(spanStyles, style)
+				}
+			}
+			p.walk(c, spanStyles, link)
+		case "a":
+			p.walk(c, styles, p.resolveLink(c.AttrOr("href", "")))
+		case "img":
+			if src := absoluteUrl(p.baseUrl, c.AttrOr("src", "")); src != "" {
+				p.runs = append(p.runs, omnikanji.RichTextRun{Image: src, Text: c.AttrOr("alt", "")})
+			}
+		case "script", "style", "noscript", "template", "iframe", "object", "embed",
+			"form", "input", "button", "select", "textarea", "svg", "math", "#comment":
+		default:
+			p.walk(c, styles, link)
+		}
+	})
+}
+
+// addText appends the text with its whitespace collapsed, merging it into the last run if it looks the same
+func (p *richTextParser) addText(text string, styles []omnikanji.RichTextStyle, link string) {
+	if text == "" {
+		return
+	}
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if unicode.IsSpace(rune(text[0])) {
+		collapsed = " " + collapsed
+	}
+	if collapsed != " " && unicode.IsSpace(rune(text[len(text)-1])) {
+		collapsed += " "
+	}
+
+	last := p.lastRun()
+	if last == nil || last.Break || last.Image != "" || strings.HasSuffix(last.Text, " ") {
+		collapsed = strings.TrimLeft(collapsed, " ")
+	}
+	if collapsed == "" {
+		return
+	}
+
+	if last != nil && !last.Break && last.Image == "" {
+		if last.Link == link && sameRichTextStyles(last.Styles, styles) {
+			last.Text += collapsed
+			return
+		}
+		// the space separating differently formatted runs belongs to the plain text, e.g. before a link
+		if strings.HasPrefix(collapsed, " ") && len(collapsed) > 1 && last.Link == "" && len(last.Styles) == 0 {
+			last.Text += " "
+			collapsed = collapsed[1:]
+		}
+	}
+	p.runs = append(p.runs, omnikanji.RichTextRun{Text: collapsed, Styles: styles, Link: link})
+}
+
+// addBreak appends a line break, unless the text is empty or already broken
+func (p *richTextParser) addBreak() {
+	p.trimEnd()
+	if last := p.lastRun(); last != nil && !last.Break {
+		p.runs = append(p.runs, omnikanji.RichTextRun{Break: true})
+	}
+}
+
+// trimEnd removes the trailing breaks and whitespace
+func (p *richTextParser) trimEnd() {
+	for last := p.lastRun(); last != nil; last = p.lastRun() {
+		if last.Image != "" {
+			return
+		}
+		last.Text = strings.TrimRight(last.Text, " ")
+		if last.Text != "" {
+			return
+		}
+		p.runs = p.runs[:len(p.runs)-1]
+	}
+}
+
+func (p *richTextParser) lastRun() *omnikanji.RichTextRun {
+	if len(p.runs) == 0 {
+		return nil
+	}
+	return &p.runs[len(p.runs)-1]
+}
+
+// resolveLink is the omnikanji search of the word the link refers to, or the absolute link otherwise.
+// Empty for links that are not http(s).
+func (p *richTextParser) resolveLink(href string) string {
+	link := absoluteUrl(p.baseUrl, href)
+	if link == "" || p.searchWord == nil {
+		return link
+	}
+	if search := internalUrl(link, p.searchWord); search != "" {
+		return search
+	}
+	return link
+}
+
+func withRichTextStyle(styles []omnikanji.RichTextStyle, style omnikanji.RichTextStyle) []omnikanji.RichTextStyle {
+	for _, s := range styles {
+		if s == style {
+			return styles
+		}
+	}
+	res := make([]omnikanji.RichTextStyle, len(styles), len(styles)+1)
+	copy(res, styles)
+	return append(res, style)
+}
+
+func sameRichTextStyles(a, b []omnikanji.RichTextStyle) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
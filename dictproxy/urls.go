@@ -0,0 +1,49 @@
+package dictproxy
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/zemiret/omnikanji"
+)
+
+// absoluteUrl makes a link scraped from a dictionary page absolute and well-formed, e.g.
+// https://jisho.org/word/%E4%BD%95 for //jisho.org/word/何. Empty if it is not a http(s) link.
+func absoluteUrl(base string, ref string) string {
+	u := resolveUrl(base, ref)
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+func resolveUrl(base string, ref string) *url.URL {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil
+	}
+	if baseUrl, err := url.Parse(base); err == nil {
+		u = baseUrl.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	return u
+}
+
+// internalUrl is the omnikanji search of the word an absolute dictionary link refers to,
+// empty if it is not a link to a word
+func internalUrl(link string, searchWord func(link *url.URL) string) string {
+	u, err := url.Parse(link)
+	if err != nil || link == "" {
+		return ""
+	}
+	if word := searchWord(u); word != "" {
+		return omnikanji.SearchUrl(word)
+	}
+	return ""
+}
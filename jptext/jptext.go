@@ -1,5 +1,7 @@
 package jptext
 
+import "strings"
+
 const (
 	kanjiStartRange = 0x4e00
 	kanjiEndRange   = 0x9faf
@@ -53,3 +55,20 @@ func KanjisCountInAWord(word string) int {
 	}
 	return kanjiCount
 }
+
+// fullWidthOffset is from the full-width form of a latin letter or digit to its ASCII form
+const fullWidthOffset = 0xfee0
+
+// NormalizeQuery is the canonical form of a search query: full-width latin letters and digits folded to ASCII,
+// the latin lowercased and the whitespace (the ideographic space too) collapsed and trimmed.
+// The kana, kanji and the full-width punctuation, e.g. ？ or ～, are left as they are, so that a Japanese
+// query stays Japanese.
+func NormalizeQuery(query string) string {
+	folded := strings.Map(func(r rune) rune {
+		if (r >= '０' && r <= '９') || (r >= 'Ａ' && r <= 'Ｚ') || (r >= 'ａ' && r <= 'ｚ') {
+			return r - fullWidthOffset
+		}
+		return r
+	}, query)
+	return strings.ToLower(strings.Join(strings.Fields(folded), " "))
+}
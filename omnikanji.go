@@ -1,16 +1,43 @@
 package omnikanji
 
+import "strings"
+
 type JishoSection struct {
-	Link        string
-	WordSection JishoWordSection
-	Kanjis      []JishoKanji
+	Link    string
+	Words   []JishoWordSection // In the order of jisho results
+	Kanjis  []JishoKanji
+	Page    int
+	HasMore bool // There are more words on the next page
+}
+
+// FirstWord is the best match for the search, nil if there are no words.
+func (s *JishoSection) FirstWord() *JishoWordSection {
+	if len(s.Words) == 0 {
+		return nil
+	}
+	return &s.Words[0]
 }
 
 type JishoWordSection struct {
-	FullWord string
-	Parts    []JishoWordPart
-	Meanings []JishoMeaning
-	//Notes *string
+	Link      string // Jisho page of the word
+	SearchUrl string // Omnikanji search of the word
+	FullWord  string
+	Parts     []JishoWordPart
+	Meanings  []JishoMeaning
+	Common    bool
+	JLPT      int      // N level of the word, 0 if it is not in JLPT
+	WaniKani  int      // Level of the word, 0 if it is not in WaniKani
+	Tags      []string // All the word labels, as shown by jisho
+	Notes     *string  // Notes on the spellings of the word, e.g. "矢張り: Ateji (phonetic) reading."
+
+	OtherForms []JishoOtherForm
+}
+
+// JishoOtherForm is an alternative spelling of a word, e.g. 矢張 for 矢張り
+type JishoOtherForm struct {
+	Word    string
+	Reading string // Empty for kana-only forms
+	Link    string // Omnikanji search of the form
 }
 
 type JishoWordPart struct {
@@ -20,10 +47,19 @@ type JishoWordPart struct {
 
 type JishoMeaning struct {
 	TemplateListItem
-	Meaning string
-	Tags    *string
-	//MeaningSentence  *string
-	//SupplementalInfo *string
+	Meaning          RichText
+	Abstract         RichText // Wikipedia summary, for wikipedia definitions
+	Tags             *string
+	Sentences        []JishoSentence
+	SupplementalInfo []string            // Labels of the meaning, e.g. "Usually written using kana alone"
+	Info             []string            // Usage notes, e.g. "with neg. sentence"
+	SeeAlso          []JishoWordWithLink // Related words, linked to their omnikanji search
+}
+
+// JishoSentence is an example sentence of a meaning
+type JishoSentence struct {
+	Parts       []JishoWordPart // Readings are set for the kanji parts only
+	Translation string
 }
 
 type TemplateListItem struct {
@@ -38,23 +74,24 @@ type JishoKanji struct {
 }
 
 type JishoWordWithLink struct {
-	Link string
-	Word string
+	Link      string // Absolute upstream link
+	SearchUrl string // Omnikanji search of the same query, empty if the link is not to a word
+	Word      string
 }
 
 type KanjidmgSection struct {
 	WordSection KanjidmgKanji
-	// TopComment  *string
-	Radicals []KanjidmgKanji
-	Onyomi   *string
-	Mnemonic *string
-	// Mutants     []KanjidmgKanji
+	TopComment  *string
+	Radicals    []KanjidmgKanji
+	Onyomi      *string
+	Mnemonic    RichText
+	Mutants     []KanjidmgKanji
 
-	// Kunyomi TODO
-	//Jukugo  TODO
-	// UsedIn TODO
-	// Synonyms TODO
-	// Lookalikes TODO
+	Kunyomi    []KanjidmgKunyomi // Sorted by usefulness
+	Jukugo     []KanjidmgJukugo  // Sorted by usefulness
+	UsedIn     []KanjidmgKanji   // Kanjis having this one as a radical
+	Synonyms   []KanjidmgKanji   // Words of the synonym groups, the meaning is the group name
+	Lookalikes []KanjidmgKanji
 }
 
 type KanjidmgKanji struct {
@@ -63,3 +100,97 @@ type KanjidmgKanji struct {
 	Meaning    string
 	Link       string
 }
+
+// SearchUrl is the omnikanji search of the kanji, empty if it only has an image
+func (k KanjidmgKanji) SearchUrl() string {
+	if k.Kanji == nil || *k.Kanji == "" {
+		return ""
+	}
+	return SearchUrl(*k.Kanji)
+}
+
+// KanjiUrl is the omnikanji kanji page, empty if it only has an image
+func (k KanjidmgKanji) KanjiUrl() string {
+	if k.Kanji == nil || *k.Kanji == "" {
+		return ""
+	}
+	return KanjiUrl(*k.Kanji)
+}
+
+type KanjidmgKunyomi struct {
+	Reading         string // Okurigana follows ＊, e.g. か＊える
+	ParticlesBefore string // e.g. を in (を) か＊える
+	ParticlesAfter  string // e.g. な in へん (な)
+	Meaning         string
+	Usefulness      Usefulness
+}
+
+type KanjidmgJukugo struct {
+	Word       string
+	Reading    string
+	Particles  string // e.g. する
+	Meaning    string
+	Usefulness Usefulness
+}
+
+// Usefulness is the kanjidamage rating of a word, 1 to 5 stars. 0 if not rated.
+type Usefulness int
+
+const MaxUsefulness = 5
+
+func (u Usefulness) Stars() string {
+	if u <= 0 {
+		return ""
+	}
+	return strings.Repeat("★", int(u)) + strings.Repeat("☆", MaxUsefulness-int(u))
+}
+
+// RichText is sanitised formatted text, scraped from the dictionaries.
+// It is a flat list of runs, so that rendering it never needs the original html.
+type RichText []RichTextRun
+
+type RichTextRun struct {
+	Text   string
+	Styles []RichTextStyle
+	Link   string // Omnikanji search for references to other words, absolute url otherwise
+	Image  string // Absolute url of an image, Text is its alt text
+	Break  bool   // Line break, the run has no text
+}
+
+type RichTextStyle string
+
+const (
+	RichTextBold        RichTextStyle = "bold"
+	RichTextItalic      RichTextStyle = "italic"
+	RichTextComponent   RichTextStyle = "component"   // Kanjidamage radical of the kanji
+	RichTextTranslation RichTextStyle = "translation" // Kanjidamage meaning of the kanji
+	RichTextOnyomi      RichTextStyle = "onyomi"
+	RichTextKunyomi     RichTextStyle = "kunyomi"
+)
+
+// String is the plain text, with breaks as new lines
+func (t RichText) String() string {
+	var b strings.Builder
+	for _, r := range t {
+		if r.Break {
+			b.WriteString("\n")
+		} else if r.Image == "" {
+			b.WriteString(r.Text)
+		}
+	}
+	return b.String()
+}
+
+// Class is the css classes of the run styles
+func (r RichTextRun) Class() string {
+	classes := make([]string, len(r.Styles))
+	for i, s := range r.Styles {
+		classes[i] = "rich-" + string(s)
+	}
+	return strings.Join(classes, " ")
+}
+
+// External is whether the run links outside of omnikanji
+func (r RichTextRun) External() bool {
+	return r.Link != "" && !strings.HasPrefix(r.Link, "/")
+}
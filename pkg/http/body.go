@@ -0,0 +1,115 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// BodyTooLargeError is returned reading a response body longer than the max body size
+type BodyTooLargeError struct {
+	MaxSize int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body longer than %d bytes", e.MaxSize)
+}
+
+// readTimeoutError is returned when a read of the body took longer than the read timeout
+type readTimeoutError struct{}
+
+func (readTimeoutError) Error() string   { return "timeout reading response body" }
+func (readTimeoutError) Timeout() bool   { return true }
+func (readTimeoutError) Temporary() bool { return true }
+
+// body limits reading the response body, and frees the request once it is closed
+type body struct {
+	io.ReadCloser
+	readTimeout time.Duration
+	left        int64 // -1 when unlimited
+	maxSize     int64
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	timedOut bool
+
+	closeOnce sync.Once
+	cancel    func()
+	release   func()
+}
+
+func newBody(rc io.ReadCloser, readTimeout time.Duration, maxSize int64, cancel, release func()) *body {
+	b := &body{ReadCloser: rc, readTimeout: readTimeout, left: -1, maxSize: maxSize, cancel: cancel, release: release}
+	if maxSize > 0 {
+		b.left = maxSize
+	}
+	return b
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.left == 0 {
+		// the limit is reached, the body is too large unless it ends exactly here
+		var one [1]byte
+		n, err := b.read(one[:])
+		if n > 0 {
+			return 0, &BodyTooLargeError{MaxSize: b.maxSize}
+		}
+		return 0, err
+	}
+	if b.left > 0 && int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.read(p)
+	if b.left > 0 {
+		b.left -= int64(n)
+	}
+	return n, err
+}
+
+// read reads from the response, cancelling the request when it takes longer than the read timeout
+func (b *body) read(p []byte) (int, error) {
+	if b.readTimeout > 0 {
+		b.mu.Lock()
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.readTimeout, b.timeout)
+		} else {
+			b.timer.Reset(b.readTimeout)
+		}
+		b.mu.Unlock()
+	}
+
+	n, err := b.ReadCloser.Read(p)
+
+	if b.readTimeout > 0 {
+		b.mu.Lock()
+		b.timer.Stop()
+		timedOut := b.timedOut
+		b.mu.Unlock()
+		if timedOut && err != nil && err != io.EOF {
+			err = readTimeoutError{}
+		}
+	}
+	return n, err
+}
+
+func (b *body) timeout() {
+	b.mu.Lock()
+	b.timedOut = true
+	b.mu.Unlock()
+	b.cancel()
+}
+
+func (b *body) Close() error {
+	err := b.ReadCloser.Close()
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.mu.Unlock()
+		b.cancel()
+		b.release()
+	})
+	return err
+}
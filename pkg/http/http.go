@@ -1,25 +1,236 @@
 package http
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
 )
 
-type Client struct {}
+// Client makes the upstream requests politely: each host has a token bucket limiting its rate,
+// and at most MaxConcurrent requests are in flight at once. A request holds its slot until its body is closed.
+// The waiting requests are served in turns between the user requests they were made for, see WithRequester.
+//
+// Failed requests (network errors, 429 and 5xx responses) are retried with a jittered exponential backoff,
+// or after the Retry-After of the response.
+type Client struct {
+	defaultRate Rate
+	hostRates   map[string]Rate
+	concurrency *semaphore // nil when unlimited
+
+	userAgent      string
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	maxBodySize    int64
+	proxy          func(*http.Request) (*url.URL, error)
+	retry          Retry
+
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	stats   map[string]*HostStats
+}
+
+// HostStats is how long the requests to a host waited for their turn
+type HostStats struct {
+	Host     string
+	Requests int
+	Waited   time.Duration
+	MaxWait  time.Duration
+}
+
+// Retry is how failed requests are retried. The zero Retry does not retry.
+type Retry struct {
+	// Attempts is how many times a request is retried after the first attempt
+	Attempts int
+	// Backoff is the wait before the first retry, doubled before each next one up to MaxBackoff.
+	// The waits are jittered between their half and their whole.
+	// A Retry-After longer than MaxBackoff, or than DefaultMaxRetryAfter without one, is not waited for.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+const (
+	DefaultUserAgent      = "omnikanji (+https://github.com/zemiret/omnikanji)"
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultReadTimeout    = 30 * time.Second
+	DefaultMaxBodySize    = 10 << 20
+	DefaultMaxRetryAfter  = 10 * time.Second
+)
+
+type Option func(c *Client)
+
+// WithDefaultRate limits the requests to the hosts without a rate of their own. The zero Rate is unlimited.
+func WithDefaultRate(rate Rate) Option {
+	return func(c *Client) {
+		c.defaultRate = rate
+	}
+}
+
+// WithHostRate limits the requests to the host, e.g. jisho.org
+func WithHostRate(host string, rate Rate) Option {
+	return func(c *Client) {
+		c.hostRates[host] = rate
+	}
+}
+
+// WithMaxConcurrent caps the requests in flight to all the hosts. Zero or less is unlimited.
+func WithMaxConcurrent(n int) Option {
+	return func(c *Client) {
+		c.concurrency = nil
+		if n > 0 {
+			c.concurrency = newSemaphore(n)
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithConnectTimeout limits establishing the connection, TLS handshake included. Zero is no limit.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.connectTimeout = timeout
+	}
+}
+
+// WithReadTimeout limits waiting for the response headers, and then for each read of the body. Zero is no limit.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.readTimeout = timeout
+	}
+}
+
+// WithMaxBodySize fails reading response bodies longer than size bytes (once decompressed). Zero or less is no limit.
+func WithMaxBodySize(size int64) Option {
+	return func(c *Client) {
+		c.maxBodySize = size
+	}
+}
+
+// WithProxy sends the requests through the proxy. Without it, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY env are used.
+func WithProxy(proxy *url.URL) Option {
+	return func(c *Client) {
+		c.proxy = http.ProxyURL(proxy)
+	}
+}
+
+// WithRetry retries the failed requests
+func WithRetry(retry Retry) Option {
+	return func(c *Client) {
+		c.retry = retry
+	}
+}
+
+// WaitError is the error of a request that was not sent, its ctx ended while it waited for its turn
+type WaitError struct {
+	Err error
+}
+
+func (e *WaitError) Error() string {
+	return "waiting for a turn: " + e.Err.Error()
+}
+
+func (e *WaitError) Unwrap() error {
+	return e.Err
+}
 
 func statusCodeError(code int) error {
 	return fmt.Errorf("status code: %d", code)
 }
 
-func NewClient() *Client {
-	return &Client{}
+// NewClient is unlimited and does not retry without options.
+// Responses are gzipped in transit, and bodies are decompressed transparently.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		hostRates:      make(map[string]Rate),
+		userAgent:      DefaultUserAgent,
+		connectTimeout: DefaultConnectTimeout,
+		readTimeout:    DefaultReadTimeout,
+		maxBodySize:    DefaultMaxBodySize,
+		proxy:          http.ProxyFromEnvironment,
+		buckets:        make(map[string]*bucket),
+		stats:          make(map[string]*HostStats),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	dialer := &net.Dialer{Timeout: c.connectTimeout, KeepAlive: 30 * time.Second}
+	c.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 c.proxy,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   c.connectTimeout,
+			ResponseHeaderTimeout: c.readTimeout,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConnsPerHost:   8,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+	return c
+}
+
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.get(ctx, url)
+		if attempt >= c.retry.Attempts || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		wait := c.retry.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > c.retry.maxRetryAfter() {
+					return resp, err // the upstream is down for longer than worth waiting
+				}
+				wait = after
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err // would not make it anyway
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if !sleep(ctx, wait) {
+			return nil, ctx.Err()
+		}
+	}
 }
 
-func (c *Client) Get(url string) (*http.Response, error) {
-	resp, err := http.Get(url)
+// get makes a single attempt of the request
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	release, err := c.wait(ctx, req.URL)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		release()
+		cancel()
 		return nil, err
 	}
+	resp.Body = newBody(resp.Body, c.readTimeout, c.maxBodySize, cancel, release)
 
 	if resp.StatusCode != http.StatusOK {
 		return resp, statusCodeError(resp.StatusCode)
@@ -28,3 +239,76 @@ func (c *Client) Get(url string) (*http.Response, error) {
 	return resp, nil
 }
 
+// wait blocks until the request may be made. The returned release frees its concurrency slot.
+func (c *Client) wait(ctx context.Context, u *url.URL) (release func(), err error) {
+	start := time.Now()
+	host := u.Hostname()
+
+	if err := c.bucket(host).take(ctx); err != nil {
+		return nil, &WaitError{Err: err}
+	}
+	release = func() {}
+	if c.concurrency != nil {
+		if err := c.concurrency.acquire(ctx); err != nil {
+			return nil, &WaitError{Err: err}
+		}
+		var once sync.Once
+		release = func() { once.Do(c.concurrency.release) }
+	}
+
+	waited := time.Since(start)
+	c.record(host, waited)
+	if observe, ok := ctx.Value(waitObserverKey{}).(func(string, time.Duration)); ok {
+		observe(host, waited)
+	}
+	return release, nil
+}
+
+func (c *Client) bucket(host string) *bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[host]
+	if !ok {
+		rate, ok := c.hostRates[host]
+		if !ok {
+			rate = c.defaultRate
+		}
+		b = newBucket(rate)
+		c.buckets[host] = b
+	}
+	return b
+}
+
+func (c *Client) record(host string, waited time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.stats[host]
+	if !ok {
+		st = &HostStats{Host: host}
+		c.stats[host] = st
+	}
+	st.Requests++
+	st.Waited += waited
+	if waited > st.MaxWait {
+		st.MaxWait = waited
+	}
+}
+
+// Stats are the waits of the requests made so far, by host
+func (c *Client) Stats() []HostStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]HostStats, 0, len(c.stats))
+	for _, st := range c.stats {
+		res = append(res, *st)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Host < res[j].Host })
+	return res
+}
+
+type waitObserverKey struct{}
+
+// WithWaitObserver makes observe called with how long each request made with ctx waited for its turn
+func WithWaitObserver(ctx context.Context, observe func(host string, waited time.Duration)) context.Context {
+	return context.WithValue(ctx, waitObserverKey{}, observe)
+}
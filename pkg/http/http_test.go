@@ -0,0 +1,305 @@
+package http_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	omnihttp "github.com/zemiret/omnikanji/pkg/http"
+)
+
+func get(t *testing.T, ctx context.Context, c *omnihttp.Client, url string) error {
+	resp, err := c.Get(ctx, url)
+	if resp != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestHostRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := omnihttp.NewClient(omnihttp.WithHostRate("127.0.0.1", omnihttp.Rate{PerSecond: 20, Burst: 2}))
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, get(t, context.Background(), c, srv.URL))
+	}
+	// the burst is immediate, the other two wait 50ms each
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	stats := c.Stats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "127.0.0.1", stats[0].Host)
+	require.Equal(t, 4, stats[0].Requests)
+	require.GreaterOrEqual(t, stats[0].Waited, 90*time.Millisecond)
+	require.GreaterOrEqual(t, stats[0].MaxWait, 40*time.Millisecond)
+
+	// other hosts are unlimited
+	c = omnihttp.NewClient(omnihttp.WithHostRate("jisho.org", omnihttp.Rate{PerSecond: 1}))
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, get(t, context.Background(), c, srv.URL))
+	}
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestLimiter(t *testing.T) {
+	l := omnihttp.NewLimiter(omnihttp.Rate{PerSecond: 20, Burst: 2})
+	require.True(t, l.Allow())
+	require.True(t, l.Allow())
+	require.False(t, l.Allow(), "the burst is used up")
+	require.Eventually(t, l.Allow, time.Second, 10*time.Millisecond)
+
+	require.True(t, omnihttp.NewLimiter(omnihttp.Rate{}).Allow())
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := omnihttp.NewClient(omnihttp.WithMaxConcurrent(2))
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, get(t, context.Background(), c, srv.URL))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), maxInFlight)
+}
+
+func TestFairTurns(t *testing.T) {
+	unblock := make(chan struct{})
+	var mu sync.Mutex
+	var served []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/first" {
+			<-unblock
+		}
+		mu.Lock()
+		served = append(served, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	c := omnihttp.NewClient(omnihttp.WithMaxConcurrent(1))
+	long := omnihttp.WithRequester(context.Background(), "long word")
+	short := omnihttp.WithRequester(context.Background(), "short word")
+
+	var wg sync.WaitGroup
+	request := func(ctx context.Context, path string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, get(t, ctx, c, srv.URL+path))
+		}()
+		time.Sleep(20 * time.Millisecond) // queued in order
+	}
+	request(long, "/first")
+	request(long, "/long1")
+	request(long, "/long2")
+	request(long, "/long3")
+	request(short, "/short")
+	close(unblock)
+	wg.Wait()
+
+	require.Equal(t, []string{"/first", "/long1", "/short", "/long2", "/long3"}, served)
+}
+
+func TestWaitCancelled(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	c := omnihttp.NewClient(omnihttp.WithMaxConcurrent(1))
+	go get(t, context.Background(), c, srv.URL)
+	time.Sleep(20 * time.Millisecond)
+
+	var waited time.Duration
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx = omnihttp.WithWaitObserver(ctx, func(_ string, d time.Duration) { waited = d })
+	require.ErrorIs(t, get(t, ctx, c, srv.URL), context.DeadlineExceeded)
+	require.Zero(t, waited)
+}
+
+func TestWaitObserver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := omnihttp.NewClient(omnihttp.WithDefaultRate(omnihttp.Rate{PerSecond: 10}))
+	var waits []time.Duration
+	ctx := omnihttp.WithWaitObserver(context.Background(), func(host string, d time.Duration) {
+		require.Equal(t, "127.0.0.1", host)
+		waits = append(waits, d)
+	})
+	require.NoError(t, get(t, ctx, c, srv.URL))
+	require.NoError(t, get(t, ctx, c, srv.URL))
+	require.Len(t, waits, 2)
+	require.Less(t, waits[0], 50*time.Millisecond)
+	require.GreaterOrEqual(t, waits[1], 50*time.Millisecond)
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	require.NoError(t, get(t, context.Background(), omnihttp.NewClient(), srv.URL))
+	require.Equal(t, omnihttp.DefaultUserAgent, userAgent)
+
+	require.NoError(t, get(t, context.Background(), omnihttp.NewClient(omnihttp.WithUserAgent("test/1.0")), srv.URL))
+	require.Equal(t, "test/1.0", userAgent)
+}
+
+func TestRetry(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/missing":
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusNotFound)
+		case "/throttled":
+			atomic.AddInt32(&attempts, 1)
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	c := omnihttp.NewClient(omnihttp.WithRetry(omnihttp.Retry{Attempts: 3, Backoff: 10 * time.Millisecond}))
+	require.NoError(t, get(t, context.Background(), c, srv.URL+"/flaky"))
+	require.Equal(t, int32(3), attempts)
+
+	// not found is not retried
+	atomic.StoreInt32(&attempts, 0)
+	require.Error(t, get(t, context.Background(), c, srv.URL+"/missing"))
+	require.Equal(t, int32(1), attempts)
+
+	// the upstream asks to wait longer than the deadline, no point in waiting
+	atomic.StoreInt32(&attempts, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	resp, err := c.Get(ctx, srv.URL+"/throttled")
+	require.Error(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp.Body.Close()
+	require.Equal(t, int32(1), attempts)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// without a deadline, the wait asked for is longer than the backoff would ever be
+	atomic.StoreInt32(&attempts, 0)
+	c = omnihttp.NewClient(omnihttp.WithRetry(omnihttp.Retry{Attempts: 3, Backoff: 10 * time.Millisecond, MaxBackoff: time.Second}))
+	start = time.Now()
+	resp, err = c.Get(context.Background(), srv.URL+"/throttled")
+	require.Error(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp.Body.Close()
+	require.Equal(t, int32(1), attempts)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close() // nothing listens there any more
+
+	c := omnihttp.NewClient(omnihttp.WithRetry(omnihttp.Retry{Attempts: 2, Backoff: 10 * time.Millisecond}))
+	var attempts int
+	ctx := omnihttp.WithWaitObserver(context.Background(), func(string, time.Duration) { attempts++ })
+	require.Error(t, get(t, ctx, c, "http://"+addr))
+	require.Equal(t, 3, attempts)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 100))
+	}))
+	defer srv.Close()
+
+	resp, err := omnihttp.NewClient(omnihttp.WithMaxBodySize(100)).Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Len(t, body, 100)
+	resp.Body.Close()
+
+	resp, err = omnihttp.NewClient(omnihttp.WithMaxBodySize(99)).Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	var tooLarge *omnihttp.BodyTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	resp.Body.Close()
+}
+
+func TestReadTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	resp, err := omnihttp.NewClient(omnihttp.WithReadTimeout(50*time.Millisecond)).Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	require.True(t, netErr.Timeout())
+}
+
+func TestGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Contains(t, r.Header.Get("Accept-Encoding"), "gzip")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("漢字"))
+		gz.Close()
+	}))
+	defer srv.Close()
+
+	resp, err := omnihttp.NewClient().Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "漢字", string(body))
+}
@@ -0,0 +1,256 @@
+package http
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type requesterKey struct{}
+
+// WithRequester tags the upstream requests made with ctx as made for the same user request.
+// Waiting requests are served in turns between the requesters, so that a search of a long word
+// does not hold the others back. Requests of untagged contexts each have a turn of their own.
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+func requesterOf(ctx context.Context) interface{} {
+	if r, ok := ctx.Value(requesterKey{}).(string); ok {
+		return r
+	}
+	return new(int) // unique
+}
+
+// waiter is a request waiting for its turn. ready is closed when it is granted.
+type waiter struct {
+	requester interface{}
+	ready     chan struct{}
+}
+
+// fairQueue serves the requesters round-robin, and the requests of a requester in order
+type fairQueue struct {
+	waiting map[interface{}][]*waiter
+	turns   []interface{} // requesters with waiting requests, the next one first
+}
+
+func (q *fairQueue) push(requester interface{}) *waiter {
+	if q.waiting == nil {
+		q.waiting = make(map[interface{}][]*waiter)
+	}
+	w := &waiter{requester: requester, ready: make(chan struct{})}
+	if len(q.waiting[requester]) == 0 {
+		q.turns = append(q.turns, requester)
+	}
+	q.waiting[requester] = append(q.waiting[requester], w)
+	return w
+}
+
+func (q *fairQueue) pop() *waiter {
+	if len(q.turns) == 0 {
+		return nil
+	}
+	requester := q.turns[0]
+	q.turns = q.turns[1:]
+	waiters := q.waiting[requester]
+	w := waiters[0]
+	if len(waiters) == 1 {
+		delete(q.waiting, requester)
+	} else {
+		q.waiting[requester] = waiters[1:]
+		q.turns = append(q.turns, requester)
+	}
+	return w
+}
+
+// remove takes a waiter that gave up out of the queue, false if it was already granted
+func (q *fairQueue) remove(w *waiter) bool {
+	waiters := q.waiting[w.requester]
+	for i, other := range waiters {
+		if other != w {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		if len(waiters) > 0 {
+			q.waiting[w.requester] = waiters
+			return true
+		}
+		delete(q.waiting, w.requester)
+		for j, r := range q.turns {
+			if r == w.requester {
+				q.turns = append(q.turns[:j:j], q.turns[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (q *fairQueue) empty() bool {
+	return len(q.turns) == 0
+}
+
+// semaphore caps the number of concurrent requests
+type semaphore struct {
+	mu    sync.Mutex
+	free  int
+	queue fairQueue
+}
+
+func newSemaphore(size int) *semaphore {
+	return &semaphore{free: size}
+}
+
+func (s *semaphore) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.free > 0 && s.queue.empty() {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	w := s.queue.push(requesterOf(ctx))
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.queue.remove(w)
+		s.mu.Unlock()
+		if !removed {
+			s.release() // granted meanwhile, pass it on
+		}
+		return ctx.Err()
+	}
+}
+
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.queue.pop(); w != nil {
+		close(w.ready)
+		return
+	}
+	s.free++
+}
+
+// Rate is how many requests per second are made to a host, with bursts of up to Burst requests
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// bucket is the token bucket of a host. The waiting requests are granted by a single pump goroutine,
+// running only while there are some.
+type bucket struct {
+	rate Rate
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	queue   fairQueue
+	pumping bool
+}
+
+func newBucket(rate Rate) *bucket {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &bucket{rate: rate, tokens: float64(rate.Burst), last: time.Now()}
+}
+
+func (b *bucket) take(ctx context.Context) error {
+	if b.rate.PerSecond <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.refill()
+	if b.tokens >= 1 && b.queue.empty() {
+		b.tokens--
+		b.mu.Unlock()
+		return nil
+	}
+	w := b.queue.push(requesterOf(ctx))
+	if !b.pumping {
+		b.pumping = true
+		go b.pump()
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		if !b.queue.remove(w) {
+			b.tokens++ // granted meanwhile, give the token back
+		}
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// allow takes a token if there is one, without waiting
+func (b *bucket) allow() bool {
+	if b.rate.PerSecond <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens >= 1 && b.queue.empty() {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// Limiter limits events to a Rate with the token bucket of the hosts, e.g. the requests made to omnikanji itself.
+// The zero Rate is unlimited.
+type Limiter struct {
+	bucket *bucket
+}
+
+func NewLimiter(rate Rate) *Limiter {
+	return &Limiter{bucket: newBucket(rate)}
+}
+
+// Allow tells whether the event is within the rate. It does not wait for one.
+func (l *Limiter) Allow() bool {
+	return l.bucket.allow()
+}
+
+func (b *bucket) pump() {
+	for {
+		b.mu.Lock()
+		b.refill()
+		for b.tokens >= 1 {
+			w := b.queue.pop()
+			if w == nil {
+				break
+			}
+			b.tokens--
+			close(w.ready)
+		}
+		if b.queue.empty() {
+			b.pumping = false
+			b.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate.PerSecond * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.PerSecond
+	if max := float64(b.rate.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
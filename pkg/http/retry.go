@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryable tells whether the failed attempt is worth retrying: network errors, throttling and server errors
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if resp == nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff is the wait before the retry following the attempt, 0-based
+func (r Retry) backoff(attempt int) time.Duration {
+	wait := r.Backoff
+	for i := 0; i < attempt && (r.MaxBackoff <= 0 || wait < r.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+		wait = r.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// maxRetryAfter is the longest Retry-After worth waiting for
+func (r Retry) maxRetryAfter() time.Duration {
+	if r.MaxBackoff > 0 {
+		return r.MaxBackoff
+	}
+	return DefaultMaxRetryAfter
+}
+
+// retryAfter is the wait asked for by the Retry-After header, in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	val := resp.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(val); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// sleep waits unless ctx is done first. It reports whether it waited the whole time.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -15,5 +15,5 @@ func NewLogger() *Logger {
 }
 
 func (lo *Logger) Errorf(err error, format string, v ...interface{}) {
-	lo.Printf(format+": "+err.Error()+"\n", v)
+	lo.Printf(format+": %s\n", append(v, err)...)
 }
@@ -6,3 +6,10 @@ func String(val string) *string {
 	}
 	return &val
 }
+
+func StringValue(val *string) string {
+	if val == nil {
+		return ""
+	}
+	return *val
+}
@@ -0,0 +1,470 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/pkg/ptr"
+)
+
+const (
+	ApiV1Prefix = "/api/v1"
+
+	ApiSearchPath  = ApiV1Prefix + "/search"
+	ApiOpenApiPath = ApiV1Prefix + "/openapi.yaml"
+)
+
+//go:embed openapi.yaml
+var openApiSpec []byte
+
+// The types below are the public, versioned shape of the JSON API.
+// They are decoupled from the omnikanji section types on purpose, so internal
+// parsing changes do not silently change the API. Any change here must be
+// reflected in openapi.yaml.
+
+type ApiSearchResponse struct {
+	Query       string               `json:"query"`
+	Jisho       *ApiJishoSection     `json:"jisho"`
+	Kanjidamage []ApiKanjidmgSection `json:"kanjidamage"`
+	Partial     bool                 `json:"partial"`
+	Errors      []ApiSourceError     `json:"errors"`
+}
+
+// ApiSourceError describes a dictionary that failed. Words not found by a dictionary are not errors.
+type ApiSourceError struct {
+	Source  string `json:"source"`
+	Kanji   string `json:"kanji,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// ApiJishoSection keeps Word as the best match for compatibility, Words holds all the words of the page.
+type ApiJishoSection struct {
+	Link         string          `json:"link"`
+	Word         ApiJishoWord    `json:"word"`
+	Words        []ApiJishoWord  `json:"words"`
+	Kanjis       []ApiJishoKanji `json:"kanjis"`
+	Page         int             `json:"page"`
+	HasMorePages bool            `json:"has_more_pages"`
+}
+
+type ApiJishoWord struct {
+	Link       string             `json:"link,omitempty"`
+	SearchLink string             `json:"search_link,omitempty"`
+	FullWord   string             `json:"full_word"`
+	Parts      []ApiJishoWordPart `json:"parts"`
+	Meanings   []ApiJishoMeaning  `json:"meanings"`
+	Common     bool               `json:"common"`
+	JLPT       int                `json:"jlpt,omitempty"`
+	WaniKani   int                `json:"wanikani,omitempty"`
+	Tags       []string           `json:"tags"`
+	Notes      string             `json:"notes,omitempty"`
+
+	OtherForms []ApiJishoOtherForm `json:"other_forms"`
+}
+
+type ApiJishoOtherForm struct {
+	Word    string `json:"word"`
+	Reading string `json:"reading,omitempty"`
+	Link    string `json:"link"`
+}
+
+type ApiJishoWordPart struct {
+	Text    string `json:"text"`
+	Reading string `json:"reading,omitempty"`
+}
+
+type ApiJishoMeaning struct {
+	Meaning      string             `json:"meaning"`
+	MeaningRich  []ApiRichTextRun   `json:"meaning_rich,omitempty"`
+	Abstract     string             `json:"abstract,omitempty"`
+	AbstractRich []ApiRichTextRun   `json:"abstract_rich,omitempty"`
+	Tags         string             `json:"tags,omitempty"`
+	Sentences    []ApiJishoSentence `json:"sentences"`
+
+	SupplementalInfo []string  `json:"supplemental_info"`
+	Info             []string  `json:"info"`
+	SeeAlso          []ApiLink `json:"see_also"`
+}
+
+// ApiRichTextRun is a piece of formatted text. Breaks and images have no text of their own.
+type ApiRichTextRun struct {
+	Text   string   `json:"text,omitempty"`
+	Styles []string `json:"styles,omitempty"`
+	Link   string   `json:"link,omitempty"`
+	Image  string   `json:"image,omitempty"`
+	Break  bool     `json:"break,omitempty"`
+}
+
+type ApiJishoSentence struct {
+	Parts       []ApiJishoWordPart `json:"parts"`
+	Translation string             `json:"translation"`
+}
+
+type ApiJishoKanji struct {
+	Kanji    ApiLink   `json:"kanji"`
+	Meaning  string    `json:"meaning"`
+	Kunyomis []ApiLink `json:"kunyomis"`
+	Onyomis  []ApiLink `json:"onyomis"`
+}
+
+// ApiLink is an upstream link, with the omnikanji search of the same word when there is one
+type ApiLink struct {
+	Text       string `json:"text"`
+	Link       string `json:"link"`
+	SearchLink string `json:"search_link,omitempty"`
+}
+
+type ApiKanjidmgSection struct {
+	Kanji    ApiKanjidmgKanji     `json:"kanji"`
+	Radicals []ApiKanjidmgKanji   `json:"radicals"`
+	Onyomi   string               `json:"onyomi,omitempty"`
+	Mnemonic string               `json:"mnemonic,omitempty"`
+	Kunyomi  []ApiKanjidmgKunyomi `json:"kunyomi"`
+	Jukugo   []ApiKanjidmgJukugo  `json:"jukugo"`
+
+	MnemonicRich []ApiRichTextRun `json:"mnemonic_rich,omitempty"`
+
+	TopComment string             `json:"top_comment,omitempty"`
+	Mutants    []ApiKanjidmgKanji `json:"mutants"`
+	UsedIn     []ApiKanjidmgKanji `json:"used_in"`
+	Synonyms   []ApiKanjidmgKanji `json:"synonyms"`
+	Lookalikes []ApiKanjidmgKanji `json:"lookalikes"`
+}
+
+type ApiKanjidmgKunyomi struct {
+	Reading         string `json:"reading"`
+	ParticlesBefore string `json:"particles_before,omitempty"`
+	ParticlesAfter  string `json:"particles_after,omitempty"`
+	Meaning         string `json:"meaning"`
+	Usefulness      int    `json:"usefulness"`
+}
+
+type ApiKanjidmgJukugo struct {
+	Word       string `json:"word"`
+	Reading    string `json:"reading"`
+	Particles  string `json:"particles,omitempty"`
+	Meaning    string `json:"meaning"`
+	Usefulness int    `json:"usefulness"`
+}
+
+type ApiKanjidmgKanji struct {
+	Kanji       string `json:"kanji,omitempty"`
+	ImageBase64 string `json:"image_base64,omitempty"`
+	Meaning     string `json:"meaning"`
+	Link        string `json:"link"`
+	SearchLink  string `json:"search_link,omitempty"`
+}
+
+type ApiErrorResponse struct {
+	Error ApiError `json:"error"`
+}
+
+type ApiError struct {
+	Status  int              `json:"status"`
+	Message string           `json:"message"`
+	Sources []ApiSourceError `json:"sources,omitempty"`
+}
+
+func (s *server) HandleApiSearch(w http.ResponseWriter, r *http.Request) {
+	s.setCorsHeaders(w)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		s.writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	word := strings.TrimSpace(r.URL.Query().Get(omnikanji.QuerySearchKey))
+	if word == "" {
+		s.writeApiError(w, http.StatusBadRequest, "missing required query parameter: "+omnikanji.QuerySearchKey)
+		return
+	}
+
+	data := s.search(r.Context(), word, parsePage(r))
+	if status := data.HttpStatus(); status != http.StatusOK {
+		apiErr := ApiError{
+			Status:  status,
+			Message: "no results for: " + word,
+			Sources: newApiSourceErrors(data),
+		}
+		if data.Error != nil {
+			apiErr.Message = *data.Error
+		}
+		s.writeApiJSON(w, status, ApiErrorResponse{Error: apiErr})
+		return
+	}
+
+	s.writeApiJSON(w, http.StatusOK, s.newApiSearchResponse(word, data))
+}
+
+func (s *server) HandleApiOpenApi(w http.ResponseWriter, r *http.Request) {
+	s.setCorsHeaders(w)
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	_, _ = w.Write(openApiSpec)
+}
+
+func (s *server) setCorsHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", "Content-Type")
+	h.Set("Access-Control-Max-Age", "86400")
+}
+
+func (s *server) writeApiError(w http.ResponseWriter, status int, msg string) {
+	s.writeApiJSON(w, status, ApiErrorResponse{
+		Error: ApiError{
+			Status:  status,
+			Message: msg,
+		},
+	})
+}
+
+func (s *server) writeApiJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.Errorf(err, "error encoding api response")
+	}
+}
+
+// siteLink is a link of a section under the BasePath. The upstream links, and no link, are kept.
+func (s *server) siteLink(link string) string {
+	if !strings.HasPrefix(link, "/") {
+		return link
+	}
+	return s.siteUrl(link)
+}
+
+func (s *server) newApiSearchResponse(word string, data *TemplateParams) *ApiSearchResponse {
+	res := &ApiSearchResponse{
+		Query:       word,
+		Kanjidamage: []ApiKanjidmgSection{},
+		Partial:     data.Partial,
+		Errors:      newApiSourceErrors(data),
+	}
+
+	if data.Jisho != nil {
+		res.Jisho = s.newApiJishoSection(data.Jisho)
+	}
+	for _, sect := range data.Kanjidmg {
+		res.Kanjidamage = append(res.Kanjidamage, s.newApiKanjidmgSection(sect))
+	}
+
+	return res
+}
+
+func newApiSourceErrors(data *TemplateParams) []ApiSourceError {
+	errs := []ApiSourceError{}
+
+	statuses := data.KanjidmgStatuses
+	if data.JishoStatus != nil {
+		statuses = append([]*SourceStatus{data.JishoStatus}, statuses...)
+	}
+	for _, st := range statuses {
+		errs = append(errs, ApiSourceError{
+			Source:  st.Source,
+			Kanji:   st.Kanji,
+			Kind:    st.Kind,
+			Message: st.Message,
+		})
+	}
+
+	return errs
+}
+
+func (s *server) newApiJishoSection(sect *omnikanji.JishoSection) *ApiJishoSection {
+	res := &ApiJishoSection{
+		Link:         sect.Link,
+		Words:        []ApiJishoWord{},
+		Kanjis:       []ApiJishoKanji{},
+		Page:         sect.Page,
+		HasMorePages: sect.HasMore,
+	}
+
+	for i := range sect.Words {
+		res.Words = append(res.Words, s.newApiJishoWord(&sect.Words[i]))
+	}
+	if len(res.Words) > 0 {
+		res.Word = res.Words[0]
+	}
+	for _, k := range sect.Kanjis {
+		res.Kanjis = append(res.Kanjis, ApiJishoKanji{
+			Kanji:    s.newApiLink(k.Kanji),
+			Meaning:  strings.TrimSpace(k.Meaning),
+			Kunyomis: s.newApiLinks(k.Kunyomis),
+			Onyomis:  s.newApiLinks(k.Onyomis),
+		})
+	}
+
+	return res
+}
+
+func (s *server) newApiJishoWord(word *omnikanji.JishoWordSection) ApiJishoWord {
+	res := ApiJishoWord{
+		Link:       word.Link,
+		SearchLink: s.siteLink(word.SearchUrl),
+		FullWord:   word.FullWord,
+		Parts:      newApiJishoWordParts(word.Parts),
+		Meanings:   []ApiJishoMeaning{},
+		Common:     word.Common,
+		JLPT:       word.JLPT,
+		WaniKani:   word.WaniKani,
+		Tags:       []string{},
+		Notes:      ptr.StringValue(word.Notes),
+
+		OtherForms: []ApiJishoOtherForm{},
+	}
+
+	res.Tags = append(res.Tags, word.Tags...)
+	for _, m := range word.Meanings {
+		res.Meanings = append(res.Meanings, ApiJishoMeaning{
+			Meaning:      m.Meaning.String(),
+			MeaningRich:  s.newApiRichText(m.Meaning),
+			Abstract:     m.Abstract.String(),
+			AbstractRich: s.newApiRichText(m.Abstract),
+			Tags:         ptr.StringValue(m.Tags),
+			Sentences:    newApiJishoSentences(m.Sentences),
+
+			SupplementalInfo: append([]string{}, m.SupplementalInfo...),
+			Info:             append([]string{}, m.Info...),
+			SeeAlso:          s.newApiLinks(m.SeeAlso),
+		})
+	}
+	for _, f := range word.OtherForms {
+		res.OtherForms = append(res.OtherForms, ApiJishoOtherForm{
+			Word:    f.Word,
+			Reading: f.Reading,
+			Link:    s.siteLink(f.Link),
+		})
+	}
+
+	return res
+}
+
+func newApiJishoWordParts(parts []omnikanji.JishoWordPart) []ApiJishoWordPart {
+	res := []ApiJishoWordPart{}
+	for _, p := range parts {
+		res = append(res, ApiJishoWordPart{
+			Text:    p.MainText,
+			Reading: p.Reading,
+		})
+	}
+	return res
+}
+
+func newApiJishoSentences(sentences []omnikanji.JishoSentence) []ApiJishoSentence {
+	res := []ApiJishoSentence{}
+	for _, s := range sentences {
+		res = append(res, ApiJishoSentence{
+			Parts:       newApiJishoWordParts(s.Parts),
+			Translation: s.Translation,
+		})
+	}
+	return res
+}
+
+func (s *server) newApiLink(w omnikanji.JishoWordWithLink) ApiLink {
+	return ApiLink{
+		Text:       w.Word,
+		Link:       w.Link,
+		SearchLink: s.siteLink(w.SearchUrl),
+	}
+}
+
+func (s *server) newApiLinks(words []omnikanji.JishoWordWithLink) []ApiLink {
+	links := []ApiLink{}
+	for _, w := range words {
+		links = append(links, s.newApiLink(w))
+	}
+	return links
+}
+
+// newApiRichText is nil for empty text, so that optional rich fields can be omitted
+func (s *server) newApiRichText(text omnikanji.RichText) []ApiRichTextRun {
+	if len(text) == 0 {
+		return nil
+	}
+	res := []ApiRichTextRun{}
+	for _, r := range text {
+		run := ApiRichTextRun{
+			Text:  r.Text,
+			Link:  s.siteLink(r.Link),
+			Image: r.Image,
+			Break: r.Break,
+		}
+		for _, style := range r.Styles {
+			run.Styles = append(run.Styles, string(style))
+		}
+		res = append(res, run)
+	}
+	return res
+}
+
+func (s *server) newApiKanjidmgSection(sect *omnikanji.KanjidmgSection) ApiKanjidmgSection {
+	res := ApiKanjidmgSection{
+		Kanji:    s.newApiKanjidmgKanji(sect.WordSection),
+		Radicals: []ApiKanjidmgKanji{},
+		Onyomi:   ptr.StringValue(sect.Onyomi),
+		Mnemonic: sect.Mnemonic.String(),
+		Kunyomi:  []ApiKanjidmgKunyomi{},
+		Jukugo:   []ApiKanjidmgJukugo{},
+
+		MnemonicRich: s.newApiRichText(sect.Mnemonic),
+
+		TopComment: ptr.StringValue(sect.TopComment),
+		Mutants:    s.newApiKanjidmgKanjis(sect.Mutants),
+		UsedIn:     s.newApiKanjidmgKanjis(sect.UsedIn),
+		Synonyms:   s.newApiKanjidmgKanjis(sect.Synonyms),
+		Lookalikes: s.newApiKanjidmgKanjis(sect.Lookalikes),
+	}
+	for _, r := range sect.Radicals {
+		res.Radicals = append(res.Radicals, s.newApiKanjidmgKanji(r))
+	}
+	for _, k := range sect.Kunyomi {
+		res.Kunyomi = append(res.Kunyomi, ApiKanjidmgKunyomi{
+			Reading:         k.Reading,
+			ParticlesBefore: k.ParticlesBefore,
+			ParticlesAfter:  k.ParticlesAfter,
+			Meaning:         k.Meaning,
+			Usefulness:      int(k.Usefulness),
+		})
+	}
+	for _, j := range sect.Jukugo {
+		res.Jukugo = append(res.Jukugo, ApiKanjidmgJukugo{
+			Word:       j.Word,
+			Reading:    j.Reading,
+			Particles:  j.Particles,
+			Meaning:    j.Meaning,
+			Usefulness: int(j.Usefulness),
+		})
+	}
+	return res
+}
+
+func (s *server) newApiKanjidmgKanjis(kanjis []omnikanji.KanjidmgKanji) []ApiKanjidmgKanji {
+	res := []ApiKanjidmgKanji{}
+	for _, k := range kanjis {
+		res = append(res, s.newApiKanjidmgKanji(k))
+	}
+	return res
+}
+
+func (s *server) newApiKanjidmgKanji(k omnikanji.KanjidmgKanji) ApiKanjidmgKanji {
+	return ApiKanjidmgKanji{
+		Kanji:       strings.TrimSpace(ptr.StringValue(k.Kanji)),
+		ImageBase64: ptr.StringValue(k.KanjiImage),
+		Meaning:     k.Meaning,
+		Link:        k.Link,
+		SearchLink:  s.siteLink(k.SearchUrl()),
+	}
+}
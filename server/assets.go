@@ -0,0 +1,75 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// assets are the page template and its css, built into the binary
+//
+//go:embed index.html css
+var assets embed.FS
+
+const indexTemplateName = "index.html"
+
+// overlayFS serves the files of top, and the files of base that top does not have
+type overlayFS struct {
+	top  fs.FS
+	base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// currentAssets are the built-in assets, with the theme files over them when there is a theme dir.
+// E.g. a theme of only css/main.css keeps the built-in page. In debug mode the assets are read
+// from the assets dir instead, if it exists, so that changes to them show up without a restart.
+func (s *server) currentAssets() fs.FS {
+	cfg := s.config()
+	var fsys fs.FS = assets
+	if cfg.DebugMode && cfg.AssetsDir != "" {
+		if info, err := os.Stat(cfg.AssetsDir); err == nil && info.IsDir() {
+			fsys = os.DirFS(cfg.AssetsDir)
+		}
+	}
+	if cfg.ThemeDir != "" {
+		fsys = overlayFS{top: os.DirFS(cfg.ThemeDir), base: fsys}
+	}
+	return fsys
+}
+
+func (s *server) parseTemplates(fsys fs.FS) (*template.Template, error) {
+	data, err := fs.ReadFile(fsys, indexTemplateName)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(indexTemplateName).Funcs(template.FuncMap{
+		"siteUrl": s.siteUrl,
+	}).Parse(string(data))
+}
+
+// templates is the parsed page, parsed again on every request in debug mode
+func (s *server) templates() (*template.Template, error) {
+	if s.config().DebugMode {
+		return s.parseTemplates(s.currentAssets())
+	}
+	return s.indexTemplate, nil
+}
+
+func (s *server) HandleCss(w http.ResponseWriter, r *http.Request) {
+	css, err := fs.Sub(s.currentAssets(), "css")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.StripPrefix("/css/", http.FileServer(http.FS(css))).ServeHTTP(w, r)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+
+	omnihttp "github.com/zemiret/omnikanji/pkg/http"
+)
+
+const ReportPath = "/report/"
+
+// maxReportSize caps the message of a problem report, in bytes
+const maxReportSize = 4 << 10
+
+// reportRate limits the reports of all the users together, so that the log cannot be flooded with them
+var reportRate = omnihttp.Rate{PerSecond: 1.0 / 6, Burst: 10}
+
+// PanicError is a panic recovered in a lookup. Id is logged with it, so that a report quoting it can be matched.
+type PanicError struct {
+	Id    string
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic %s: %v", e.Id, e.Value)
+}
+
+// newErrorId is a random id short enough to quote in a report
+func newErrorId() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// logPanic logs the recovered value with its stack under a new error id
+func (s *server) logPanic(p interface{}, stack []byte) string {
+	id := newErrorId()
+	s.Printf("panic %s: %v\n%s", id, p, stack)
+	return id
+}
+
+// recoverLookup turns a panic of a source lookup into its error result, so that the other sources are still shown.
+// It must be deferred by the goroutine of the lookup.
+func (s *server) recoverLookup(ctx context.Context, results chan<- lookupResult, res lookupResult) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	id := s.logPanic(p, debug.Stack())
+	s.handleLookupErr(ctx, results, res, &PanicError{Id: id, Value: p})
+}
+
+// recoverer answers the requests that panicked with the error page, which has the error id and a report form,
+// or the API error with the id.
+// Once the response has started, e.g. of a stream, the panic is only logged.
+func (s *server) recoverer(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			stack := debug.Stack()
+			id := s.logPanic(p, stack)
+			if rw.wroteHeader {
+				return
+			}
+			if strings.HasPrefix(r.URL.Path, ApiV1Prefix) {
+				s.writeApiError(w, http.StatusInternalServerError, "internal error "+id)
+				return
+			}
+
+			tParams := s.errorPage(http.StatusInternalServerError, "Something went wrong on our side.")
+			tParams.ErrorId = id
+			if s.config().DebugMode {
+				tParams.ErrorDetails = fmt.Sprintf("%v\n\n%s", p, stack)
+			}
+			s.renderTemplate(w, tParams)
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter tells whether the response has started. It flushes, for the streams.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap is for http.ResponseController, e.g. to set the deadlines of a stream
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// HandleReport logs a problem report sent from the error page. The reports of other sites are refused.
+func (s *server) HandleReport(w http.ResponseWriter, r *http.Request) {
+	if crossOrigin(r) {
+		s.renderError(w, http.StatusForbidden, "Reports can only be sent from the error page.")
+		return
+	}
+	if !s.reports.Allow() {
+		s.renderError(w, http.StatusTooManyRequests, "Too many reports were sent lately, please try again later.")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxReportSize)
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest, "The report could not be read.")
+		return
+	}
+
+	id := r.PostForm.Get("id")
+	message := strings.TrimSpace(r.PostForm.Get("message"))
+	if len(message) > maxReportSize {
+		end := maxReportSize
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end]
+	}
+	s.Printf("problem report for error %q from %s: %q", id, r.Referer(), message)
+
+	s.renderTemplate(w, &TemplateParams{Notice: "Thank you for the report, it helps fixing the problem."})
+}
+
+// crossOrigin tells whether the browser sent the request from another site.
+// Requests without the headers, e.g. of curl, are not cross-site forgeries.
+func crossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
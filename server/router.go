@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/jptext"
+)
+
+// route serves a path, or every path under it if prefix is set.
+// Routes without methods leave answering the other methods to the handler, e.g. the JSON API.
+type route struct {
+	path    string
+	prefix  bool
+	methods []string
+	handler http.HandlerFunc
+}
+
+var pageMethods = []string{http.MethodGet, http.MethodHead}
+
+func (rt *route) matches(path string) bool {
+	if rt.prefix {
+		return strings.HasPrefix(path, rt.path)
+	}
+	return path == rt.path
+}
+
+func (rt *route) allows(method string) bool {
+	if rt.methods == nil {
+		return true
+	}
+	for _, m := range rt.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) routes() []route {
+	page := s.renderWrapper(s.HandleIndex)
+	return []route{
+		{path: "/", methods: pageMethods, handler: page},
+		{path: omnikanji.WordPath, prefix: true, methods: pageMethods, handler: page},
+		{path: omnikanji.KanjiPath, prefix: true, methods: pageMethods, handler: page},
+		{path: omnikanji.SearchPath, prefix: true, methods: pageMethods, handler: s.HandleSearchForm},
+		{path: SearchStreamPath, methods: pageMethods, handler: s.HandleSearchStream},
+		{path: ApiSearchPath, handler: s.HandleApiSearch},
+		{path: ApiOpenApiPath, handler: s.HandleApiOpenApi},
+		{path: ReportPath, methods: []string{http.MethodPost}, handler: s.HandleReport},
+		{path: "/css/", prefix: true, methods: pageMethods, handler: s.HandleCss},
+	}
+}
+
+// Handler serves the site under the BasePath, e.g. for a larger service to mount it:
+//
+//	mux.Handle("/omnikanji/", srv.Handler()) // with BasePath /omnikanji
+func (s *server) Handler() http.Handler {
+	return s.handler
+}
+
+func (s *server) newHandler() http.Handler {
+	router := s.recoverer(s.newRouter())
+	basePath := s.config().BasePath
+	if basePath == "" {
+		return router
+	}
+	return http.StripPrefix(basePath, router)
+}
+
+// siteUrl is the url of a path of the site, under the BasePath
+func (s *server) siteUrl(path string) string {
+	return s.config().BasePath + path
+}
+
+// newRouter routes the requests of the site, the first matching route wins.
+// Unknown paths and methods get the error page with a 404 and 405.
+func (s *server) newRouter() http.Handler {
+	routes := s.routes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" { // the BasePath without the slash
+			http.Redirect(w, r, s.siteUrl("/"), http.StatusMovedPermanently)
+			return
+		}
+		for _, rt := range routes {
+			if !rt.matches(r.URL.Path) {
+				continue
+			}
+			if !rt.allows(r.Method) {
+				w.Header().Set("Allow", strings.Join(rt.methods, ", "))
+				s.renderError(w, http.StatusMethodNotAllowed, fmt.Sprintf("This page cannot be requested with %s.", r.Method))
+				return
+			}
+			rt.handler(w, r)
+			return
+		}
+		s.renderError(w, http.StatusNotFound, "There is no such page.")
+	})
+}
+
+// HandleSearchForm redirects the searches of the form, and the old search urls, to the word permalinks.
+// The other query parameters, e.g. the page, are kept.
+func (s *server) HandleSearchForm(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	word := jptext.NormalizeQuery(query.Get(omnikanji.QuerySearchKey))
+	if word == "" {
+		http.Redirect(w, r, s.siteUrl("/"), http.StatusFound)
+		return
+	}
+	query.Del(omnikanji.QuerySearchKey)
+	redirectWithQuery(w, r, s.siteUrl(omnikanji.SearchUrl(word)), query)
+}
+
+// redirectWithQuery redirects permanently to target with the query appended
+func redirectWithQuery(w http.ResponseWriter, r *http.Request, target string, query url.Values) {
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// pathParam is the unescaped rest of the path after prefix. The escaped path is used,
+// so that an escaped slash stays a part of the param.
+func pathParam(r *http.Request, prefix string) (string, bool) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	param, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", false
+	}
+	return param, true
+}
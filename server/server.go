@@ -1,37 +1,66 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/dictproxy"
 	"github.com/zemiret/omnikanji/jptext"
+	omnihttp "github.com/zemiret/omnikanji/pkg/http"
 	"github.com/zemiret/omnikanji/pkg/logger"
+	"github.com/zemiret/omnikanji/pkg/ptr"
 )
 
 type TemplateDataGetHandler func(w http.ResponseWriter, r *http.Request) *TemplateParams
 
+// responded is returned by the TemplateDataGetHandlers that have written the response themselves, e.g. a redirect
+var responded = &TemplateParams{}
+
 type JishoSectionGetter interface {
 	Url(word string) string
-	Get(word string) (*omnikanji.JishoSection, error)
+	Get(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error)
 }
 
 type KanjidmgSectionGetter interface {
-	Get(kanji rune) (*omnikanji.KanjidmgSection, error)
+	Get(ctx context.Context, kanji rune) (*omnikanji.KanjidmgSection, error)
 }
 
 type server struct {
 	*logger.Logger
-	cfg           *omnikanji.Config
+	cfg           atomic.Value // *omnikanji.Config
 	indexTemplate *template.Template
 	kanjidmgLinks map[string]string
 	jisho         JishoSectionGetter
 	kanjidmg      KanjidmgSectionGetter
+	reports       *omnihttp.Limiter
+
+	handler    http.Handler
+	httpServer *http.Server
+	// lookups numbers the lookups, so that the upstream requests of each get their fair turns
+	lookups uint64
+	// inFlight are the lookups in progress, waited for on shutdown. Once the shutdown runs out of time,
+	// stopped is done and they are cancelled. The lookups started after the shutdown, closed under mu,
+	// are cancelled right away.
+	mu          sync.Mutex
+	closed      bool
+	inFlight    sync.WaitGroup
+	stopped     context.Context
+	stopLookups context.CancelFunc
 }
 
 type TemplateParams struct {
@@ -40,154 +69,573 @@ type TemplateParams struct {
 	Jisho                *omnikanji.JishoSection
 	Kanjidmg             []*omnikanji.KanjidmgSection
 	Error                *string
+	Partial              bool
+	JishoStatus          *SourceStatus
+	KanjidmgStatuses     []*SourceStatus
+	Stream               *StreamParams
+	// ErrorStatus is set for the pages that cannot be served, e.g. 404
+	ErrorStatus int
+	// ErrorId is the id a panic was logged with, ErrorDetails its value and stack, shown in debug mode only
+	ErrorId      string
+	ErrorDetails string
+	// Notice is a message shown instead of the results, e.g. after a report
+	Notice string
+	// CanonicalUrl is the permalink of the page, built from the normalised query
+	CanonicalUrl string
+
+	searchedWord string
+	wordsShown   int
+}
+
+// NewServer parses the page template, built-in or of the theme dir of cfg, and sets up the http server
+// listening at the ListenAddr of cfg
+func NewServer(cfg *omnikanji.Config, jisho JishoSectionGetter, kanjidmg KanjidmgSectionGetter) (*server, error) {
+	s := &server{
+		jisho:    jisho,
+		kanjidmg: kanjidmg,
+		reports:  omnihttp.NewLimiter(reportRate),
+		Logger:   logger.NewLogger(),
+	}
+	s.SetConfig(cfg)
+
+	indexTemplate, err := s.parseTemplates(s.currentAssets())
+	if err != nil {
+		return nil, fmt.Errorf("parsing templates: %w", err)
+	}
+	s.indexTemplate = indexTemplate
+
+	s.stopped, s.stopLookups = context.WithCancel(context.Background())
+	s.handler = s.newHandler()
+	s.httpServer = &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      s.handler,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
+	}
+	return s, nil
 }
 
-func NewServer(cfg *omnikanji.Config, indexTemplate *template.Template, jisho JishoSectionGetter, kanjidmg KanjidmgSectionGetter) *server {
-	return &server{
-		cfg:           cfg,
-		indexTemplate: indexTemplate,
-		jisho:         jisho,
-		kanjidmg:      kanjidmg,
-		Logger:        logger.NewLogger(),
+// SetConfig swaps the config in, e.g. on reload. The requests in progress finish with the previous one.
+// The BasePath is the one of NewServer, the handler is routed under it, so a different one is ignored.
+func (s *server) SetConfig(cfg *omnikanji.Config) {
+	if cur, ok := s.cfg.Load().(*omnikanji.Config); ok && cfg.BasePath != cur.BasePath {
+		s.Printf("the base path cannot change while running, keeping %q", cur.BasePath)
+		keep := *cfg
+		keep.BasePath = cur.BasePath
+		cfg = &keep
 	}
+	s.cfg.Store(cfg)
 }
 
-func (s *server) Start() {
-	http.HandleFunc("/", s.renderWrapper(s.HandleIndex))
-	http.Handle("/css/", http.StripPrefix("/css/", http.FileServer(http.Dir("css"))))
-	log.Println("Starting server at localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+func (s *server) config() *omnikanji.Config {
+	return s.cfg.Load().(*omnikanji.Config)
 }
 
-func (s *server) HandleIndex(w http.ResponseWriter, r *http.Request) *TemplateParams {
-	if s.cfg.DebugMode {
-		s.Printf("Request for: %s", r.URL.Path)
+// HttpServer is the http server Start runs, it can be adjusted before
+func (s *server) HttpServer() *http.Server {
+	return s.httpServer
+}
+
+// Start serves until Shutdown, after which it returns nil
+func (s *server) Start() error {
+	log.Printf("Starting server at %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
 
-	if strings.HasPrefix(r.URL.Path, "/search/") {
-		return s.handleSearch(w, r)
+// Shutdown stops accepting requests and waits for the ones in progress and their lookups, those of an
+// embedding service too, to finish. If ctx is done first, the lookups left are cancelled, so that their
+// requests are answered with what was found so far, and the error of ctx is returned.
+func (s *server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.stopLookups()
 	}
 
-	return nil
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.stopLookups()
+		<-drained
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
 }
 
-func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) *TemplateParams {
-	word := r.URL.Query().Get(omnikanji.QuerySearchKey)
-	if word == "" {
-		http.Redirect(w, r, "/", http.StatusFound)
+// HandleIndex gets the page of the path: the index, a word or a kanji. Other paths are not found.
+func (s *server) HandleIndex(w http.ResponseWriter, r *http.Request) *TemplateParams {
+	if s.config().DebugMode {
+		s.Printf("Request for: %s", r.URL.Path)
+	}
+
+	switch {
+	case r.URL.Path == "/":
 		return nil
+	case strings.HasPrefix(r.URL.Path, omnikanji.WordPath):
+		return s.handleWord(w, r)
+	case strings.HasPrefix(r.URL.Path, omnikanji.KanjiPath):
+		return s.handleKanji(w, r)
 	}
+	return s.errorPage(http.StatusNotFound, "There is no such page.")
+}
 
-	if !jptext.IsJapaneseWord(word) {
-		return s.searchFromEnglish(word)
+// handleWord searches the word of the permalink. Words that are not normalised are redirected
+// to their canonical permalink.
+func (s *server) handleWord(w http.ResponseWriter, r *http.Request) *TemplateParams {
+	word, ok := pathParam(r, omnikanji.WordPath)
+	if !ok {
+		return s.errorPage(http.StatusNotFound, "There is no such page.")
+	}
+	normalized := jptext.NormalizeQuery(word)
+	if normalized == "" {
+		http.Redirect(w, r, s.siteUrl("/"), http.StatusFound)
+		return responded
+	}
+	if normalized != word {
+		redirectWithQuery(w, r, s.siteUrl(omnikanji.SearchUrl(normalized)), r.URL.Query())
+		return responded
 	}
 
-	return s.searchFromJapanese(word)
+	page := parsePage(r)
+
+	var tParams *TemplateParams
+	if s.isStreaming(r) {
+		tParams = s.streamParams(word, page)
+	} else {
+		tParams = s.search(r.Context(), word, page)
+	}
+	tParams.CanonicalUrl = searchPageUrl(word, page)
+	return tParams
 }
 
-func (s *server) searchFromEnglish(word string) *TemplateParams {
-	var wg sync.WaitGroup
-	var tParams TemplateParams
-	s.doJishoSearch(&wg, &tParams, word)
-	wg.Wait()
+// handleKanji looks up the kanji of the permalink at kanjidamage only
+func (s *server) handleKanji(w http.ResponseWriter, r *http.Request) *TemplateParams {
+	param, ok := pathParam(r, omnikanji.KanjiPath)
+	kanji := jptext.NormalizeQuery(param)
+	if !ok || utf8.RuneCountInString(kanji) != 1 || !jptext.IsKanjiWord(kanji) {
+		return s.errorPage(http.StatusNotFound, "There is no such kanji page.")
+	}
+	if kanji != param {
+		redirectWithQuery(w, r, s.siteUrl(omnikanji.KanjiUrl(kanji)), r.URL.Query())
+		return responded
+	}
+
+	tParams := s.collectResults(kanji, s.lookupKanji(r.Context(), kanji))
+	tParams.CanonicalUrl = omnikanji.KanjiUrl(kanji)
+	return tParams
+}
 
-	if tParams.Jisho == nil {
-		return &TemplateParams{}
+// parsePage returns the requested page of the results, 1 if not given or invalid
+func parsePage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get(omnikanji.QueryPageKey))
+	if err != nil || page < 1 {
+		return 1
 	}
+	return page
+}
 
-	var wordKanjis string
-	for _, c := range tParams.Jisho.WordSection.FullWord {
-		if jptext.IsKanji(c) {
-			wordKanjis += string(c)
+func (s *server) search(ctx context.Context, word string, page int) *TemplateParams {
+	return s.collectResults(word, s.lookup(ctx, word, page))
+}
+
+// collectResults waits for all the results of the lookup of word
+func (s *server) collectResults(word string, results <-chan lookupResult) *TemplateParams {
+	tParams := s.newTemplateParams(word)
+	var kanjidmgResults []*omnikanji.KanjidmgSection
+	var kanjidmgStatuses []*SourceStatus
+
+	for res := range results {
+		switch res.kind {
+		case lookupResultJisho:
+			tParams.Jisho = res.jisho
+		case lookupResultKanjidmgStart:
+			kanjidmgResults = make([]*omnikanji.KanjidmgSection, utf8.RuneCountInString(res.kanjis))
+			kanjidmgStatuses = make([]*SourceStatus, utf8.RuneCountInString(res.kanjis))
+		case lookupResultKanjidmg:
+			kanjidmgResults[res.kanjidmgIdx] = res.kanjidmg
+		case lookupResultJishoError:
+			tParams.JishoStatus = res.status
+		case lookupResultKanjidmgError:
+			kanjidmgStatuses[res.kanjidmgIdx] = res.status
+		}
+		if res.status != nil && res.status.Kind == SourceStatusTimeout {
+			tParams.Partial = true
+		}
+	}
+
+	for _, r := range kanjidmgResults {
+		if r != nil {
+			tParams.Kanjidmg = append(tParams.Kanjidmg, r)
+		}
+	}
+	for _, st := range kanjidmgStatuses {
+		if st != nil {
+			tParams.KanjidmgStatuses = append(tParams.KanjidmgStatuses, st)
 		}
 	}
 
-	if wordKanjis != "" {
-		s.doKanjidmgSearch(&tParams, wordKanjis)
+	if tParams.Jisho == nil && tParams.Kanjidmg == nil && (tParams.JishoStatus != nil || tParams.KanjidmgStatuses != nil) {
+		tParams.Error = ptr.String("The dictionaries could not be reached, please try again later.")
 	}
 
-	tParams.EnglishSearchedWord = word
-	tParams.JishoEnglishWordLink = s.jisho.Url(word)
-	tParams.Jisho.Link = s.jisho.Url(tParams.Jisho.WordSection.FullWord) // overwrite english word link
+	if !jptext.IsJapaneseWord(word) && tParams.Jisho != nil {
+		tParams.EnglishSearchedWord = word
+		tParams.JishoEnglishWordLink = s.jisho.Url(word)
+	}
 
-	return &tParams
+	return tParams
 }
 
-func (s *server) searchFromJapanese(word string) *TemplateParams {
-	data := s.getSections(word)
-	return data
+func (s *server) newTemplateParams(word string) *TemplateParams {
+	return &TemplateParams{
+		searchedWord: word,
+		wordsShown:   s.config().JishoWordsShown,
+	}
 }
 
-func (s *server) getSections(word string) *TemplateParams {
-	var tParams TemplateParams
-	var wg sync.WaitGroup
+// TopJishoWords are the jisho words shown expanded
+func (p *TemplateParams) TopJishoWords() []omnikanji.JishoWordSection {
+	if p.Jisho == nil {
+		return nil
+	}
+	if p.wordsShown <= 0 || len(p.Jisho.Words) <= p.wordsShown {
+		return p.Jisho.Words
+	}
+	return p.Jisho.Words[:p.wordsShown]
+}
+
+// MoreJishoWords are the jisho words shown collapsed, after TopJishoWords
+func (p *TemplateParams) MoreJishoWords() []omnikanji.JishoWordSection {
+	if p.Jisho == nil || p.wordsShown <= 0 || len(p.Jisho.Words) <= p.wordsShown {
+		return nil
+	}
+	return p.Jisho.Words[p.wordsShown:]
+}
 
-	s.doJishoSearch(&wg, &tParams, word)
+// PrevPageUrl is the link to the previous page of jisho words, empty on the first page
+func (p *TemplateParams) PrevPageUrl() string {
+	if p.Jisho == nil || p.Jisho.Page <= 1 {
+		return ""
+	}
+	return searchPageUrl(p.searchedWord, p.Jisho.Page-1)
+}
 
-	wordKanjis := jptext.ExtractKanjis(word)
-	if wordKanjis != "" {
-		s.doKanjidmgSearch(&tParams, wordKanjis)
+// NextPageUrl is the link to the next page of jisho words, empty if there are no more words
+func (p *TemplateParams) NextPageUrl() string {
+	if p.Jisho == nil || !p.Jisho.HasMore {
+		return ""
 	}
+	return searchPageUrl(p.searchedWord, p.Jisho.Page+1)
+}
+
+func searchPageUrl(word string, page int) string {
+	if page <= 1 {
+		return omnikanji.SearchUrl(word)
+	}
+	return omnikanji.SearchUrl(word) + "?" + url.Values{omnikanji.QueryPageKey: {strconv.Itoa(page)}}.Encode()
+}
 
+type lookupResultKind int
+
+const (
+	lookupResultJisho lookupResultKind = iota
+	lookupResultKanjidmgStart
+	lookupResultKanjidmg
+	lookupResultJishoError
+	lookupResultKanjidmgError
+)
+
+// lookupResult is a single piece of the lookup, sent as soon as it is ready.
+// lookupResultKanjidmgStart announces which kanjis are going to be looked up at kanjidamage,
+// lookupResultKanjidmg carries the section for the kanji at kanjidmgIdx of those.
+// The error results carry the status of a source that failed. Words not found by a source are not errors.
+type lookupResult struct {
+	kind lookupResultKind
+
+	jisho *omnikanji.JishoSection
+
+	kanjis      string
+	kanjidmgIdx int
+	kanjidmg    *omnikanji.KanjidmgSection
+
+	status *SourceStatus
+}
+
+// lookup searches all the dictionaries for the word and sends the results in the order they arrive.
+// The channel is closed once every search is done or ctx is cancelled. It must be drained by the caller.
+func (s *server) lookup(ctx context.Context, word string, page int) <-chan lookupResult {
+	return s.runLookup(ctx, word, func(ctx context.Context, results chan<- lookupResult) {
+		if !jptext.IsJapaneseWord(word) {
+			s.lookupFromEnglish(ctx, results, word, page)
+		} else {
+			s.lookupFromJapanese(ctx, results, word, page)
+		}
+	})
+}
+
+// lookupKanji looks up a single kanji at kanjidamage only, see lookup
+func (s *server) lookupKanji(ctx context.Context, kanji string) <-chan lookupResult {
+	return s.runLookup(ctx, kanji, func(ctx context.Context, results chan<- lookupResult) {
+		var wg sync.WaitGroup
+		s.doKanjidmgSearch(ctx, &wg, results, kanji)
+		wg.Wait()
+	})
+}
+
+// runLookup runs the search in the background, with its own turns at the upstreams, and closes the channel after it.
+func (s *server) runLookup(ctx context.Context, word string, search func(ctx context.Context, results chan<- lookupResult)) <-chan lookupResult {
+	results := make(chan lookupResult)
+	ctx = omnihttp.WithRequester(ctx, "lookup-"+strconv.FormatUint(atomic.AddUint64(&s.lookups, 1), 10))
+
+	// the lookup is cancelled too when the shutdown runs out of time
+	ctx, cancel := context.WithCancel(ctx)
+	go func(done <-chan struct{}) {
+		select {
+		case <-s.stopped.Done():
+			cancel()
+		case <-done:
+		}
+	}(ctx.Done())
+
+	tracked := s.track()
+	if !tracked {
+		cancel()
+	}
+	go func() {
+		if tracked {
+			defer s.inFlight.Done()
+		}
+		defer cancel()
+		defer close(results)
+		// the english lookups get jisho in this goroutine
+		defer s.recoverLookup(ctx, results, lookupResult{kind: lookupResultJishoError})
+		if s.config().DebugMode {
+			var waited int64
+			ctx = omnihttp.WithWaitObserver(ctx, func(_ string, d time.Duration) {
+				atomic.AddInt64(&waited, int64(d))
+			})
+			defer func() {
+				s.Printf("lookup %q waited %s for upstream turns", word, time.Duration(atomic.LoadInt64(&waited)))
+			}()
+		}
+		search(ctx, results)
+	}()
+
+	return results
+}
+
+// track adds a lookup to inFlight, unless the shutdown already waits for them
+func (s *server) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.inFlight.Add(1)
+	return true
+}
+
+// lookupFromEnglish looks up the kanjis of the best jisho match at kanjidamage.
+// Further pages of english results do not have a best match, so kanjidamage is skipped for them.
+func (s *server) lookupFromEnglish(ctx context.Context, results chan<- lookupResult, word string, page int) {
+	jishoSection, err := s.getJisho(ctx, word, page)
+	if err != nil {
+		s.handleLookupErr(ctx, results, lookupResult{kind: lookupResultJishoError}, err)
+		return
+	}
+
+	sortEnglishCandidates(jishoSection.Words)
+
+	firstWord := jishoSection.FirstWord().FullWord
+	jishoSection.Link = s.jisho.Url(firstWord) // overwrite english word link
+	if !sendResult(ctx, results, lookupResult{kind: lookupResultJisho, jisho: jishoSection}) {
+		return
+	}
+
+	if page > 1 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	s.doKanjidmgSearch(ctx, &wg, results, jptext.ExtractKanjis(firstWord))
+	wg.Wait()
+}
+
+// sortEnglishCandidates puts the words a learner most likely looks for first: common words,
+// then by JLPT level (N5 first), then by WaniKani level. Otherwise the jisho order is kept.
+func sortEnglishCandidates(words []omnikanji.JishoWordSection) {
+	sort.SliceStable(words, func(i, j int) bool {
+		a, b := &words[i], &words[j]
+		if a.Common != b.Common {
+			return a.Common
+		}
+		if a.JLPT != b.JLPT {
+			return a.JLPT > b.JLPT
+		}
+		if a.WaniKani != b.WaniKani {
+			return b.WaniKani == 0 || (a.WaniKani != 0 && a.WaniKani < b.WaniKani)
+		}
+		return false
+	})
+}
+
+func (s *server) lookupFromJapanese(ctx context.Context, results chan<- lookupResult, word string, page int) {
+	var wg sync.WaitGroup
+	s.doJishoSearch(ctx, &wg, results, word, page)
+	s.doKanjidmgSearch(ctx, &wg, results, jptext.ExtractKanjis(word))
 	wg.Wait()
-	return &tParams
 }
 
-func (s *server) doJishoSearch(wg *sync.WaitGroup, tParams *TemplateParams, word string) {
+func (s *server) doJishoSearch(ctx context.Context, wg *sync.WaitGroup, results chan<- lookupResult, word string, page int) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		jishoSection, err := s.jisho.Get(word)
+		defer s.recoverLookup(ctx, results, lookupResult{kind: lookupResultJishoError})
+		jishoSection, err := s.getJisho(ctx, word, page)
 		if err != nil {
-			s.Errorf(err, "error getting jisho section")
+			s.handleLookupErr(ctx, results, lookupResult{kind: lookupResultJishoError}, err)
 			return
 		}
-		tParams.Jisho = jishoSection
+		sendResult(ctx, results, lookupResult{kind: lookupResultJisho, jisho: jishoSection})
 	}()
 }
 
-func (s *server) doKanjidmgSearch(tParams *TemplateParams, word string) {
-	var wg sync.WaitGroup
+func (s *server) doKanjidmgSearch(ctx context.Context, wg *sync.WaitGroup, results chan<- lookupResult, kanjis string) {
+	if kanjis == "" {
+		return
+	}
+
+	if !sendResult(ctx, results, lookupResult{kind: lookupResultKanjidmgStart, kanjis: kanjis}) {
+		return
+	}
 
-	results := make([]*omnikanji.KanjidmgSection, utf8.RuneCountInString(word))
+	// All the kanjis share a single deadline, they are fetched in parallel anyway
+	kanjidmgCtx, cancel := withTimeout(ctx, s.config().KanjidmgTimeout)
+
+	var kanjidmgWg sync.WaitGroup
 	idx := 0
-	for _, c := range word {
-		wg.Add(1)
+	for _, c := range kanjis {
+		kanjidmgWg.Add(1)
 		go func(i int, c rune) {
-			defer wg.Done()
-			sect, err := s.kanjidmg.Get(c)
+			defer kanjidmgWg.Done()
+			errRes := lookupResult{kind: lookupResultKanjidmgError, kanjidmgIdx: i, kanjis: string(c)}
+			defer s.recoverLookup(ctx, results, errRes)
+			sect, err := s.kanjidmg.Get(kanjidmgCtx, c)
 			if err != nil {
-				s.Errorf(err, "error getting kanjidmg section")
+				s.handleLookupErr(ctx, results, errRes, err)
 				return
 			}
-			results[i] = sect
+			sendResult(ctx, results, lookupResult{kind: lookupResultKanjidmg, kanjidmgIdx: i, kanjidmg: sect})
 		}(idx, c)
 		idx++
 	}
 
-	wg.Wait()
-	for _, r := range results {
-		if r != nil {
-			tParams.Kanjidmg = append(tParams.Kanjidmg, r)
-		}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		kanjidmgWg.Wait()
+		cancel()
+	}()
+}
+
+func (s *server) getJisho(ctx context.Context, word string, page int) (*omnikanji.JishoSection, error) {
+	jishoCtx, cancel := withTimeout(ctx, s.config().JishoTimeout)
+	defer cancel()
+	return s.jisho.Get(jishoCtx, word, page)
+}
+
+// handleLookupErr sends the status of a failed source as the given error result.
+// Not found words and errors caused by the client going away are not failures.
+func (s *server) handleLookupErr(ctx context.Context, results chan<- lookupResult, res lookupResult, err error) {
+	if ctx.Err() != nil || errors.Is(err, dictproxy.ErrNotFound) {
+		return
+	}
+
+	if res.kind == lookupResultJishoError {
+		s.Errorf(err, "error getting jisho section")
+		res.status = newSourceStatus(dictproxy.SourceJisho, "", err)
+	} else {
+		s.Errorf(err, "error getting kanjidmg section")
+		res.status = newSourceStatus(dictproxy.SourceKanjidmg, res.kanjis, err)
 	}
+
+	sendResult(ctx, results, res)
+}
+
+// sendResult sends res unless ctx is done first. It reports whether res was sent.
+func sendResult(ctx context.Context, results chan<- lookupResult, res lookupResult) bool {
+	select {
+	case results <- res:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withTimeout is context.WithTimeout where a non-positive timeout means no timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 func (s *server) errorParams(msg string) *TemplateParams {
 	return &TemplateParams{Error: &msg}
 }
 
+// errorPage is the page of a request that cannot be served at all, e.g. of an unknown path
+func (s *server) errorPage(status int, msg string) *TemplateParams {
+	tParams := s.errorParams(msg)
+	tParams.ErrorStatus = status
+	return tParams
+}
+
+func (s *server) renderError(w http.ResponseWriter, status int, msg string) {
+	s.renderTemplate(w, s.errorPage(status, msg))
+}
+
 func (s *server) renderTemplate(w http.ResponseWriter, data *TemplateParams) {
-	err := s.indexTemplate.ExecuteTemplate(w, "index.html", data)
+	tpl, err := s.templates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	err = tpl.ExecuteTemplate(&buf, indexTemplateName, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	// No params means there was no search, the index is shown
+	if data != nil {
+		w.WriteHeader(data.HttpStatus())
+	}
+	_, _ = buf.WriteTo(w)
 }
 
 func (s *server) renderWrapper(h TemplateDataGetHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := h(w, r)
-		if s.cfg.DebugMode {
+		if data == responded {
+			return
+		}
+		if s.config().DebugMode {
 			tplDump, err := json.MarshalIndent(data, "", "  ")
 			if err != nil {
 				s.Printf("template dump err: %s", err)
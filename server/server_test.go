@@ -1,7 +1,10 @@
 package server_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,14 +14,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/cache"
 	"github.com/zemiret/omnikanji/dictproxy"
 	"github.com/zemiret/omnikanji/jptext"
+	omnihttp "github.com/zemiret/omnikanji/pkg/http"
 	"github.com/zemiret/omnikanji/server"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 type HttpClientMock struct {
@@ -31,25 +39,31 @@ func NewHttpClientMock(staticDir string) *HttpClientMock {
 	}
 }
 
-func (c *HttpClientMock) Get(searchUrl string) (*http.Response, error) {
+func (c *HttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
 	log.Println("Mock Get url: ", searchUrl)
 
 	var filePath string
 
 	if strings.HasPrefix(searchUrl, omnikanji.JishoSearchUrl) {
-		word := strings.TrimPrefix(searchUrl, omnikanji.JishoSearchUrl) // this could go onto "jisho" aggregate not to spill out its logic
+		word, err := url.PathUnescape(strings.TrimPrefix(searchUrl, omnikanji.JishoSearchUrl)) // this could go onto "jisho" aggregate not to spill out its logic
+		if err != nil {
+			return nil, err
+		}
 		filePath = filepath.Join(c.staticDir, "jisho", word+".html")
 	} else if strings.HasPrefix(searchUrl, omnikanji.KanjidmgBaseUrl) {
-		urlPath := strings.TrimPrefix(searchUrl, omnikanji.KanjidmgBaseUrl) // this could go onto "kanjidmg" aggregate not to spill out its logic
+		urlPath, err := url.PathUnescape(strings.TrimPrefix(searchUrl, omnikanji.KanjidmgBaseUrl)) // this could go onto "kanjidmg" aggregate not to spill out its logic
+		if err != nil {
+			return nil, err
+		}
 
 		// Mock image return for image radicals
-		if strings.HasPrefix(urlPath, "//assets") {
+		if strings.HasPrefix(urlPath, "/assets") {
 			return &http.Response{
 				Body: ioutil.NopCloser(strings.NewReader("imagebytes")),
 			}, nil
 		}
 
-		word := urlPath
+		word := strings.TrimPrefix(urlPath, "/kanji/")
 		filePath = filepath.Join(c.staticDir, "kanjidmg", word+".html")
 	}
 
@@ -63,12 +77,15 @@ func (c *HttpClientMock) Get(searchUrl string) (*http.Response, error) {
 	}, nil
 }
 
+var update = flag.Bool("update", false, "update the golden files of TestServer")
+
 func TestServer(t *testing.T) {
 	type TestCase struct {
 		word   string
 		expect func(t *testing.T, res *server.TemplateParams)
 
-		expectJSON string
+		// golden compares the search result with fixture/golden/<word>.json
+		golden bool
 	}
 
 	testCases := []*TestCase{
@@ -80,1164 +97,55 @@ func TestServer(t *testing.T) {
 			},
 		},
 		{
-			word: "何",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/何",
-				  "WordSection": {
-					"FullWord": "何",
-					"Parts": [
-					  {
-						"MainText": "何",
-						"Reading": "なに"
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "what",
-						"Tags": "Pronoun"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "you-know-what; that thing",
-						"Tags": "Pronoun"
-					  },
-					  {
-						"ListIdx": 3,
-						"Meaning": "whatsit; whachamacallit; what's-his-name; what's-her-name",
-						"Tags": "Pronoun"
-					  },
-					  {
-						"ListIdx": 4,
-						"Meaning": "penis; (one's) thing; dick",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 5,
-						"Meaning": "(not) at all; (not) in the slightest",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 6,
-						"Meaning": "what?; huh?",
-						"Tags": null
-					  },
-					  {
-						"ListIdx": 7,
-						"Meaning": "hey!; come on!",
-						"Tags": null
-					  },
-					  {
-						"ListIdx": 8,
-						"Meaning": "oh, no (it's fine); why (it's nothing); oh (certainly not)",
-						"Tags": null
-					  },
-					  {
-						"ListIdx": 9,
-						"Meaning": "ナニ",
-						"Tags": "Other forms"
-					  }
-					]
-				  },
-				  "Kanjis": [
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E4%BD%95%20%23kanji",
-						"Word": "何"
-					  },
-					  "Meaning": "\n            what\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E4%BD%95%20%E3%81%AA%E3%81%AB",
-						  "Word": "なに"
-						},
-						{
-						  "Link": "//jisho.org/search/%E4%BD%95%20%E3%81%AA%E3%82%93",
-						  "Word": "なん"
-						},
-						{
-						  "Link": "//jisho.org/search/%E4%BD%95%20%E3%81%AA%E3%81%AB",
-						  "Word": "なに-"
-						},
-						{
-						  "Link": "//jisho.org/search/%E4%BD%95%20%E3%81%AA%E3%82%93",
-						  "Word": "なん-"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E4%BD%95%20%E3%81%8B",
-						  "Word": "カ"
-						}
-					  ]
-					}
-				  ]
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "何",
-					  "KanjiImage": null,
-					  "Meaning": "what?!?",
-					  "Link": "http://www.kanjidamage.com何"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "personleft",
-						"Link": "http://www.kanjidamage.com//kanji/61-person-%E4%BA%BA"
-					  },
-					  {
-						"Kanji": "可",
-						"KanjiImage": null,
-						"Meaning": "possible",
-						"Link": "http://www.kanjidamage.com//kanji/55-possible-%E5%8F%AF"
-					  }
-					],
-					"Onyomi": "KA, but you don't need to learn it",
-					"Mnemonic": "When you say \" WHAAT????\", you are asking that person if what they just said is really possible"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "兄弟",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/兄弟",
-				  "WordSection": {
-					"FullWord": "兄弟",
-					"Parts": [
-					  {
-						"MainText": "兄",
-						"Reading": "きょう"
-					  },
-					  {
-						"MainText": "弟",
-						"Reading": "だい"
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "siblings; brothers and sisters",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "brothers",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 3,
-						"Meaning": "siblings-in-law; brothers-in-law; sisters-in-law",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 4,
-						"Meaning": "mate; friend",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 5,
-						"Meaning": "兄弟 【けいてい】",
-						"Tags": "Other forms"
-					  }
-					]
-				  },
-				  "Kanjis": [
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E5%85%84%20%23kanji",
-						"Word": "兄"
-					  },
-					  "Meaning": "\n            elder brother, \n            big brother\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%85%84%20%E3%81%82%E3%81%AB",
-						  "Word": "あに"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%85%84%20%E3%81%91%E3%81%84",
-						  "Word": "ケイ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%85%84%20%E3%81%8D%E3%82%87%E3%81%86",
-						  "Word": "キョウ"
-						}
-					  ]
-					},
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E5%BC%9F%20%23kanji",
-						"Word": "弟"
-					  },
-					  "Meaning": "\n            younger brother, \n            faithful service to elders\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%BC%9F%20%E3%81%8A%E3%81%A8%E3%81%86%E3%81%A8",
-						  "Word": "おとうと"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%BC%9F%20%E3%81%A6%E3%81%84",
-						  "Word": "テイ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%BC%9F%20%E3%81%A0%E3%81%84",
-						  "Word": "ダイ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%BC%9F%20%E3%81%A7",
-						  "Word": "デ"
-						}
-					  ]
-					}
-				  ]
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "兄",
-					  "KanjiImage": null,
-					  "Meaning": "older brother",
-					  "Link": "http://www.kanjidamage.com兄"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "口",
-						"KanjiImage": null,
-						"Meaning": "mouth/small box radical",
-						"Link": "http://www.kanjidamage.com//kanji/9-mouth-small-box-radical-%E5%8F%A3"
-					  },
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "human legs",
-						"Link": "http://www.kanjidamage.com//kanji/519-human-legs"
-					  }
-					],
-					"Onyomi": "KYOU / KEI\n\n\nas in \"KYOU (今日)  my older brother acted OK. Tomorrow he'll be a bully again",
-					"Mnemonic": "My older brother is eating so much, he is basically a mouth on legs"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "弟",
-					  "KanjiImage": null,
-					  "Meaning": "younger brother",
-					  "Link": "http://www.kanjidamage.com弟"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "horny",
-						"Link": "http://www.kanjidamage.com//kanji/859-horny"
-					  },
-					  {
-						"Kanji": "弓",
-						"KanjiImage": null,
-						"Meaning": "bow",
-						"Link": "http://www.kanjidamage.com//kanji/892-bow-%E5%BC%93"
-					  },
-					  {
-						"Kanji": "  丶",
-						"KanjiImage": null,
-						"Meaning": "dot",
-						"Link": "http://www.kanjidamage.com//kanji/1769-dot-%E4%B8%B6"
-					  }
-					],
-					"Onyomi": "TEI, DAI",
-					"Mnemonic": "When my younger brother gets horny he TAKES a bow like Cupid and shoots you and you DIE. \nHe's passionate but unfortunately not gifted with a sense of poetic metaphor"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "路面電車停留場",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/路面電車停留場",
-				  "WordSection": {
-					"FullWord": "路面電車停留場",
-					"Parts": null,
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "Tram stop",
-						"Tags": "Wikipedia definition"
-					  }
-					]
-				  },
-				  "Kanjis": [
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E9%9D%A2%20%23kanji",
-						"Word": "面"
-					  },
-					  "Meaning": "\n            mask, \n            face, \n            features, \n            surface\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E9%9D%A2%20%E3%81%8A%E3%82%82",
-						  "Word": "おも"
-						},
-						{
-						  "Link": "//jisho.org/search/%E9%9D%A2%20%E3%81%8A%E3%82%82%E3%81%A6",
-						  "Word": "おもて"
-						},
-						{
-						  "Link": "//jisho.org/search/%E9%9D%A2%20%E3%81%A4%E3%82%89",
-						  "Word": "つら"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E9%9D%A2%20%E3%82%81%E3%82%93",
-						  "Word": "メン"
-						},
-						{
-						  "Link": "//jisho.org/search/%E9%9D%A2%20%E3%81%B9%E3%82%93",
-						  "Word": "ベン"
-						}
-					  ]
-					},
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E9%9B%BB%20%23kanji",
-						"Word": "電"
-					  },
-					  "Meaning": "\n            electricity\n      ",
-					  "Kunyomis": null,
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E9%9B%BB%20%E3%81%A7%E3%82%93",
-						  "Word": "デン"
-						}
-					  ]
-					},
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E8%BB%8A%20%23kanji",
-						"Word": "車"
-					  },
-					  "Meaning": "\n            car\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E8%BB%8A%20%E3%81%8F%E3%82%8B%E3%81%BE",
-						  "Word": "くるま"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E8%BB%8A%20%E3%81%97%E3%82%83",
-						  "Word": "シャ"
-						}
-					  ]
-					},
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E5%A0%B4%20%23kanji",
-						"Word": "場"
-					  },
-					  "Meaning": "\n            location, \n            place\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%A0%B4%20%E3%81%B0",
-						  "Word": "ば"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%A0%B4%20%E3%81%98%E3%82%87%E3%81%86",
-						  "Word": "ジョウ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%A0%B4%20%E3%81%A1%E3%82%87%E3%81%86",
-						  "Word": "チョウ"
-						}
-					  ]
-					}
-				  ]
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "路",
-					  "KanjiImage": null,
-					  "Meaning": "road",
-					  "Link": "http://www.kanjidamage.com路"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "足",
-						"KanjiImage": null,
-						"Meaning": "foot/ be enough",
-						"Link": "http://www.kanjidamage.com//kanji/277-foot-be-enough-%E8%B6%B3"
-					  },
-					  {
-						"Kanji": "各",
-						"KanjiImage": null,
-						"Meaning": "each",
-						"Link": "http://www.kanjidamage.com//kanji/609-each-%E5%90%84"
-					  }
-					],
-					"Onyomi": "RO\n\n\nshould be easy to remember, because it sounds like ROad",
-					"Mnemonic": "Each foot walks on the same road"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "面",
-					  "KanjiImage": null,
-					  "Meaning": "front surface / face",
-					  "Link": "http://www.kanjidamage.com面"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "terrorist",
-						"Link": "http://www.kanjidamage.com//kanji/812-terrorist"
-					  },
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "big box",
-						"Link": "http://www.kanjidamage.com//kanji/431-big-box"
-					  }
-					],
-					"Onyomi": "MEN",
-					"Mnemonic": "there seems to be a LADDER in the center of this kanji! So we can say . . \n\n\nThe terrorist MEN climbed up the ladder onto the surface of the big box (a Walmart) and said they'd blow it up unless the Government did the Humpty"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "電",
-					  "KanjiImage": null,
-					  "Meaning": "electricity",
-					  "Link": "http://www.kanjidamage.com電"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "雨",
-						"KanjiImage": null,
-						"Meaning": "rain",
-						"Link": "http://www.kanjidamage.com//kanji/1383-rain-%E9%9B%A8"
-					  },
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "dragon radical",
-						"Link": "http://www.kanjidamage.com//kanji/1400-dragon-radical"
-					  }
-					],
-					"Onyomi": "DEN\n\n\nI flip the switch and DEN ('then') the electric light goes on",
-					"Mnemonic": "Electricity comes from lightning in the rain and also from the lightning breath of dragons"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "車",
-					  "KanjiImage": null,
-					  "Meaning": "car",
-					  "Link": "http://www.kanjidamage.com車"
-					},
-					"Radicals": null,
-					"Onyomi": "SHA\n\n\nTo get from A to B, you SHALL need a car",
-					"Mnemonic": null
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "停",
-					  "KanjiImage": null,
-					  "Meaning": "bring to a halt",
-					  "Link": "http://www.kanjidamage.com停"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "personleft",
-						"Link": "http://www.kanjidamage.com//kanji/61-person-%E4%BA%BA"
-					  },
-					  {
-						"Kanji": "亭",
-						"KanjiImage": null,
-						"Meaning": "restaurant",
-						"Link": "http://www.kanjidamage.com//kanji/81-restaurant-%E4%BA%AD"
-					  }
-					],
-					"Onyomi": "TEI",
-					"Mnemonic": "The police person brings a halt to the restaurant because too many people got a damn nail in their sandwitch and what the hell kind of deal is that, anyway? Imagine the pain"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "留",
-					  "KanjiImage": null,
-					  "Meaning": "absent / stopped",
-					  "Link": "http://www.kanjidamage.com留"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "decapitated cow",
-						"Link": "http://www.kanjidamage.com//kanji/1244-decapitated-cow"
-					  },
-					  {
-						"Kanji": "刀",
-						"KanjiImage": null,
-						"Meaning": "sword",
-						"Link": "http://www.kanjidamage.com//kanji/164-sword-%E5%88%80"
-					  },
-					  {
-						"Kanji": "田",
-						"KanjiImage": null,
-						"Meaning": "rice field",
-						"Link": "http://www.kanjidamage.com//kanji/56-rice-field-%E7%94%B0"
-					  }
-					],
-					"Onyomi": "RYUU\n\n\nYou'll have to REUSE that phone to call me later - I'm away from home right now",
-					"Mnemonic": "The cow's head is away - it was chopped off with a sword and then hidden in a rice field. Kids these days"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "場",
-					  "KanjiImage": null,
-					  "Meaning": "place",
-					  "Link": "http://www.kanjidamage.com場"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "土",
-						"KanjiImage": null,
-						"Meaning": "earth",
-						"Link": "http://www.kanjidamage.com//kanji/235-earth-%E5%9C%9F"
-					  },
-					  {
-						"Kanji": "易",
-						"KanjiImage": null,
-						"Meaning": "easy",
-						"Link": "http://www.kanjidamage.com//kanji/1203-easy-%E6%98%93"
-					  }
-					],
-					"Onyomi": "JOU\n\n\nforget it",
-					"Mnemonic": "It's easy for JOE Stalin to take over all places on the earth with his legions of fanatics"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "あったり前",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/あったり前",
-				  "WordSection": {
-					"FullWord": "当ったり前",
-					"Parts": [
-					  {
-						"MainText": "当",
-						"Reading": "あ"
-					  },
-					  {
-						"MainText": "ったり",
-						"Reading": ""
-					  },
-					  {
-						"MainText": "前",
-						"Reading": "まえ"
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "natural; reasonable; obvious",
-						"Tags": "Na-adjective (keiyodoshi), Noun which may take the genitive case particle 'no', Noun"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "usual; common; ordinary",
-						"Tags": "Na-adjective (keiyodoshi), Noun, Noun which may take the genitive case particle 'no'"
-					  }
-					]
-				  },
-				  "Kanjis": [
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E5%89%8D%20%23kanji",
-						"Word": "前"
-					  },
-					  "Meaning": "\n            in front, \n            before\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%89%8D%20%E3%81%BE%E3%81%88",
-						  "Word": "まえ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%89%8D%20%E3%81%BE%E3%81%88",
-						  "Word": "-まえ"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%89%8D%20%E3%81%9C%E3%82%93",
-						  "Word": "ゼン"
-						}
-					  ]
-					}
-				  ]
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "前",
-					  "KanjiImage": null,
-					  "Meaning": "before",
-					  "Link": "http://www.kanjidamage.com前"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "standing worms",
-						"Link": "http://www.kanjidamage.com//kanji/147-standing-worms"
-					  },
-					  {
-						"Kanji": "月",
-						"KanjiImage": null,
-						"Meaning": "moon/organ",
-						"Link": "http://www.kanjidamage.com//kanji/41-moon-organ-%E6%9C%88"
-					  },
-					  {
-						"Kanji": "刀",
-						"KanjiImage": null,
-						"Meaning": "sword",
-						"Link": "http://www.kanjidamage.com//kanji/164-sword-%E5%88%80"
-					  }
-					],
-					"Onyomi": "ZEN\n\n\nZEN is before NOW. That is a terrible pun but now you won't be able to forget it",
-					"Mnemonic": "Cut the standing worms with your sword before the full moon. Otherwise they'll turn into WERE-worms, of which the less said, the better"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "相変わらず",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/相変わらず",
-				  "WordSection": {
-					"FullWord": "相変わらず",
-					"Parts": [
-					  {
-						"MainText": "相",
-						"Reading": "あい"
-					  },
-					  {
-						"MainText": "変",
-						"Reading": "か"
-					  },
-					  {
-						"MainText": "わらず",
-						"Reading": ""
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "as usual; as always; as before; as ever; still",
-						"Tags": "Adverb (fukushi), Noun which may take the genitive case particle 'no'"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "相変らず 【あいかわらず】、あい変わらず 【あいかわらず】、あい変らず 【あいかわらず】",
-						"Tags": "Other forms"
-					  }
-					]
-				  },
-				  "Kanjis": [
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E7%9B%B8%20%23kanji",
-						"Word": "相"
-					  },
-					  "Meaning": "\n            inter-, \n            mutual, \n            together, \n            each other, \n            minister of state, \n            councillor, \n            aspect, \n            phase, \n            physiognomy\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E7%9B%B8%20%E3%81%82%E3%81%84",
-						  "Word": "あい-"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E7%9B%B8%20%E3%81%9D%E3%81%86",
-						  "Word": "ソウ"
-						},
-						{
-						  "Link": "//jisho.org/search/%E7%9B%B8%20%E3%81%97%E3%82%87%E3%81%86",
-						  "Word": "ショウ"
-						}
-					  ]
-					},
-					{
-					  "Kanji": {
-						"Link": "//jisho.org/search/%E5%A4%89%20%23kanji",
-						"Word": "変"
-					  },
-					  "Meaning": "\n            unusual, \n            change, \n            strange\n      ",
-					  "Kunyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%A4%89%20%E3%81%8B%E3%82%8F%E3%82%8B",
-						  "Word": "か.わる"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%A4%89%20%E3%81%8B%E3%82%8F%E3%82%8A",
-						  "Word": "か.わり"
-						},
-						{
-						  "Link": "//jisho.org/search/%E5%A4%89%20%E3%81%8B%E3%81%88%E3%82%8B",
-						  "Word": "か.える"
-						}
-					  ],
-					  "Onyomis": [
-						{
-						  "Link": "//jisho.org/search/%E5%A4%89%20%E3%81%B8%E3%82%93",
-						  "Word": "ヘン"
-						}
-					  ]
-					}
-				  ]
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "相",
-					  "KanjiImage": null,
-					  "Meaning": "partner",
-					  "Link": "http://www.kanjidamage.com相"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "木",
-						"KanjiImage": null,
-						"Meaning": "tree",
-						"Link": "http://www.kanjidamage.com//kanji/335-tree-%E6%9C%A8"
-					  },
-					  {
-						"Kanji": "目",
-						"KanjiImage": null,
-						"Meaning": "eye",
-						"Link": "http://www.kanjidamage.com//kanji/76-eye-%E7%9B%AE"
-					  }
-					],
-					"Onyomi": "SOU\n\n\nHe's got SO many partners",
-					"Mnemonic": "If your partner has a tree splinter in their eye, you have to take it out even if it is really gross. And vice versa"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "変",
-					  "KanjiImage": null,
-					  "Meaning": "change",
-					  "Link": "http://www.kanjidamage.com変"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "赤",
-						"KanjiImage": null,
-						"Meaning": "red",
-						"Link": "http://www.kanjidamage.com//kanji/900-red-%E8%B5%A4"
-					  },
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "eachbottom",
-						"Link": "http://www.kanjidamage.com//kanji/609-each-%E5%90%84"
-					  }
-					],
-					"Onyomi": "HEN\n\n\nThe egg changes into a HEN after hatching",
-					"Mnemonic": "Each red Communist changes into a yuppie when they turn 30"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "ペラペラ",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/ペラペラ",
-				  "WordSection": {
-					"FullWord": "ペラペラ",
-					"Parts": [
-					  {
-						"MainText": "ペラペラ",
-						"Reading": ""
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "fluently (speaking a foreign language)",
-						"Tags": "Adverb (fukushi), Adverb taking the 'to' particle, Na-adjective (keiyodoshi)"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "incessantly (speaking); glibly; garrulously; volubly",
-						"Tags": "Adverb (fukushi), Adverb taking the 'to' particle"
-					  },
-					  {
-						"ListIdx": 3,
-						"Meaning": "one after the other (flipping through pages)",
-						"Tags": "Adverb (fukushi), Adverb taking the 'to' particle"
-					  },
-					  {
-						"ListIdx": 4,
-						"Meaning": "thin (paper, cloth, etc.); flimsy; weak",
-						"Tags": "Noun which may take the genitive case particle 'no', Na-adjective (keiyodoshi), Adverb (fukushi), Suru verb"
-					  },
-					  {
-						"ListIdx": 5,
-						"Meaning": "ぺらぺら",
-						"Tags": "Other forms"
-					  }
-					]
-				  },
-				  "Kanjis": null
-				},
-				"Kanjidmg": null,
-				"Error": null
-			  }`,
-		},
-		{
-			word: "driver's licence",
-			expectJSON: `{
-				"EnglishSearchedWord": "driver's licence",
-				"JishoEnglishWordLink": "https://jisho.org/search/driver's licence",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/運転免許",
-				  "WordSection": {
-					"FullWord": "運転免許",
-					"Parts": [
-					  {
-						"MainText": "運",
-						"Reading": "うん"
-					  },
-					  {
-						"MainText": "転",
-						"Reading": "てん"
-					  },
-					  {
-						"MainText": "免",
-						"Reading": "めん"
-					  },
-					  {
-						"MainText": "許",
-						"Reading": "きょ"
-					  }
-					],
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "driver's license; driver's licence; driving licence",
-						"Tags": "Noun"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "Driver's license",
-						"Tags": "Wikipedia definition"
-					  }
-					]
-				  },
-				  "Kanjis": null
-				},
-				"Kanjidmg": [
-				  {
-					"WordSection": {
-					  "Kanji": "運",
-					  "KanjiImage": null,
-					  "Meaning": "carry / luck",
-					  "Link": "http://www.kanjidamage.com運"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "motion",
-						"Link": "http://www.kanjidamage.com//kanji/327-motion"
-					  },
-					  {
-						"Kanji": "軍",
-						"KanjiImage": null,
-						"Meaning": "army",
-						"Link": "http://www.kanjidamage.com//kanji/1068-army-%E8%BB%8D"
-					  }
-					],
-					"Onyomi": "UN",
-					"Mnemonic": "I wish the army luck when they move forward with their campaign - hopefully they only meet UN-armed civilians"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "転",
-					  "KanjiImage": null,
-					  "Meaning": "roll over",
-					  "Link": "http://www.kanjidamage.com転"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "車",
-						"KanjiImage": null,
-						"Meaning": "car",
-						"Link": "http://www.kanjidamage.com//kanji/1058-car-%E8%BB%8A"
-					  },
-					  {
-						"Kanji": "云",
-						"KanjiImage": null,
-						"Meaning": "twin decapited cows",
-						"Link": "http://www.kanjidamage.com//kanji/1265-twin-decapited-cows-%E4%BA%91"
-					  }
-					],
-					"Onyomi": "TEN",
-					"Mnemonic": "The car rolled over the twin cows TEN times, decapitating them"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "免",
-					  "KanjiImage": null,
-					  "Meaning": "exemption / license",
-					  "Link": "http://www.kanjidamage.com免"
-					},
-					"Radicals": [
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "bait",
-						"Link": "http://www.kanjidamage.com//kanji/1209-bait"
-					  },
-					  {
-						"Kanji": null,
-						"KanjiImage": "aW1hZ2VieXRlcw==",
-						"Meaning": "human legs",
-						"Link": "http://www.kanjidamage.com//kanji/519-human-legs"
-					  }
-					],
-					"Onyomi": "MEN\n\n\nMEN have a license to urinate standing up",
-					"Mnemonic": "You need a fishing license to walk to the river on your human legs and throw your baited worm in"
-				  },
-				  {
-					"WordSection": {
-					  "Kanji": "許",
-					  "KanjiImage": null,
-					  "Meaning": "allow",
-					  "Link": "http://www.kanjidamage.com許"
-					},
-					"Radicals": [
-					  {
-						"Kanji": "言",
-						"KanjiImage": null,
-						"Meaning": "say",
-						"Link": "http://www.kanjidamage.com//kanji/11-say-%E8%A8%80"
-					  },
-					  {
-						"Kanji": "午",
-						"KanjiImage": null,
-						"Meaning": "noon",
-						"Link": "http://www.kanjidamage.com//kanji/1192-noon-%E5%8D%88"
-					  }
-					],
-					"Onyomi": "KYO",
-					"Mnemonic": "Keep Your Opinions to yourself until I allow you to say them at noon"
-				  }
-				],
-				"Error": null
-			  }`,
-		},
-		{
-			word: "やはり",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/やはり",
-				  "WordSection": {
-					"FullWord": "矢張り",
-					"Parts": null,
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "as expected; sure enough; just as one thought",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "after all (is said and done); in the end; as one would expect; in any case",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 3,
-						"Meaning": "too; also; as well; likewise; (not) either",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 4,
-						"Meaning": "still; as before",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 5,
-						"Meaning": "all the same; even so; still; nonetheless",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 6,
-						"Meaning": "矢張 【やはり】",
-						"Tags": "Other forms"
-					  },
-					  {
-						"ListIdx": 7,
-						"Meaning": "",
-						"Tags": "Notes"
-					  }
-					]
-				  },
-				  "Kanjis": [ "notemptyarrayIExpect" ]
-				},
-				"Kanjidmg": null,
-				"Error": null
-			  }`,
-		},
-		{
-			word: "矢張り",
-			expectJSON: `{
-				"EnglishSearchedWord": "",
-				"JishoEnglishWordLink": "",
-				"Jisho": {
-				  "Link": "https://jisho.org/search/やはり",
-				  "WordSection": {
-					"FullWord": "矢張り",
-					"Parts": null,
-					"Meanings": [
-					  {
-						"ListIdx": 1,
-						"Meaning": "as expected; sure enough; just as one thought",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 2,
-						"Meaning": "after all (is said and done); in the end; as one would expect; in any case",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 3,
-						"Meaning": "too; also; as well; likewise; (not) either",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 4,
-						"Meaning": "still; as before",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 5,
-						"Meaning": "all the same; even so; still; nonetheless",
-						"Tags": "Adverb (fukushi)"
-					  },
-					  {
-						"ListIdx": 6,
-						"Meaning": "矢張 【やはり】",
-						"Tags": "Other forms"
-					  },
-					  {
-						"ListIdx": 7,
-						"Meaning": "",
-						"Tags": "Notes"
-					  }
-					]
-				  },
-				  "Kanjis": [ "notemptyarrayIExpect" ]
-				},
-				"Kanjidmg": null,
-				"Error": null
-			  }`,
+			word:   "何",
+			golden: true,
+			expect: func(t *testing.T, res *server.TemplateParams) {
+				require.Len(t, res.Jisho.Words, 20)
+				require.Equal(t, 1, res.Jisho.Page)
+				require.True(t, res.Jisho.HasMore)
+			},
 		},
+		{word: "兄弟", golden: true},
+		{word: "路面電車停留場", golden: true},
+		{word: "あったり前", golden: true},
+		{word: "相変わらず", golden: true},
+		{word: "ペラペラ", golden: true},
+		{word: "driver's licence", golden: true},
+		{word: "やはり", golden: true},
+		{word: "矢張り", golden: true},
 	}
 
-	// "special case" (fill by hand) for creating kanjidmg lookup urls (when looked up words do not contain the kanjis, but jisho returns kanjis)
-	kanjidmgLinkWords := []string{
-		"運転免許",
-		"矢張",
-	}
+	var words []string
 	for _, tc := range testCases {
-		kanjidmgLinkWords = append(kanjidmgLinkWords, tc.word)
-	}
-	kanjidmgLinks := make(map[string]string)
-	for _, word := range kanjidmgLinkWords {
-		for _, r := range word {
-			if !jptext.IsKanji(r) {
-				continue
-			}
-
-			rStr := string(r)
-			kanjidmgLinks[rStr] = omnikanji.KanjidmgBaseUrl + rStr
-		}
+		words = append(words, tc.word)
 	}
+	srv := newTestServer(t, words...)
 
 	getData := func(t *testing.T, tc *TestCase) *server.TemplateParams {
-		fixtureDir, err := filepath.Abs("fixture")
-		require.NoError(t, err)
-
-		httpClient := NewHttpClientMock(fixtureDir)
-		jisho := dictproxy.NewJisho(omnikanji.JishoSearchUrl, httpClient)
-		kanjidmg := dictproxy.NewKanjidmg(kanjidmgLinks, httpClient)
-		srv := server.NewServer(nil, jisho, kanjidmg)
-
-		queryWord := url.QueryEscape(tc.word)
-
-		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:8080/search/?word=%s", queryWord), nil)
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl(tc.word), nil)
 
 		data := srv.HandleIndex(nil, req)
 		return data
 	}
 
-	// t.Run("generate JSONs", func(t *testing.T) {
-	// 	for _, tc := range testCases {
-	// 		t.Run(tc.word, func(t *testing.T) {
-	// 			data := getData(t, tc)
-
-	// 			b, _ := json.MarshalIndent(data, "", "  ")
-	// 			log.Println(string(b))
-	// 			t.Fail()
-	// 		})
-	// 	}
-	// })
-
 	for _, tc := range testCases {
 		t.Run(tc.word, func(t *testing.T) {
 			data := getData(t, tc)
 
-			require.False(t, tc.expect == nil && tc.expectJSON == "", "No expects? You sure?")
+			require.False(t, tc.expect == nil && !tc.golden, "No expects? You sure?")
 
-			if tc.expectJSON != "" {
+			if tc.golden {
 				dataB, err := json.MarshalIndent(data, "", "  ")
 				require.NoError(t, err)
-				require.JSONEq(t, tc.expectJSON, string(dataB))
+
+				goldenPath := filepath.Join("fixture", "golden", tc.word+".json")
+				if *update {
+					require.NoError(t, ioutil.WriteFile(goldenPath, append(dataB, '\n'), 0644))
+				}
+
+				expectJSON, err := ioutil.ReadFile(goldenPath)
+				require.NoError(t, err)
+				require.JSONEq(t, string(expectJSON), string(dataB))
 			}
 
 			if tc.expect != nil {
@@ -1246,3 +154,1064 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestCache(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+	httpClient := &RecordingHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir)}
+	cfg := &omnikanji.Config{CacheSize: 100, CacheDir: t.TempDir(), CacheTTL: time.Hour}
+	srv := newTestServerWithClient(t, cfg, httpClient, "兄弟")
+
+	expectJSON, err := ioutil.ReadFile(filepath.Join("fixture", "golden", "兄弟.json"))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		data := srv.HandleIndex(nil, req)
+
+		dataB, err := json.Marshal(data)
+		require.NoError(t, err)
+		require.JSONEq(t, string(expectJSON), string(dataB), "cached sections are the same as the parsed ones")
+	}
+	require.Len(t, httpClient.urls, 5, "the second search is served from the cache") // jisho, 2 kanjis and 2 radical images
+}
+
+func TestApiSearch(t *testing.T) {
+	srv := newTestServer(t, "何")
+
+	doRequest := func(method, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080"+server.ApiSearchPath+query, nil)
+		rec := httptest.NewRecorder()
+		srv.HandleApiSearch(rec, req)
+		return rec
+	}
+
+	t.Run("found", func(t *testing.T) {
+		rec := doRequest(http.MethodGet, "?word="+url.QueryEscape("何"))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		require.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+
+		var res server.ApiSearchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Equal(t, "何", res.Query)
+		require.NotNil(t, res.Jisho)
+		require.Equal(t, "何", res.Jisho.Word.FullWord)
+		require.Equal(t, "https://jisho.org/word/%E4%BD%95", res.Jisho.Word.Link)
+		require.Equal(t, "/w/"+url.PathEscape("何"), res.Jisho.Word.SearchLink)
+		require.Equal(t, server.ApiLink{Text: "何", Link: "https://jisho.org/search/%E4%BD%95%20%23kanji", SearchLink: "/w/" + url.PathEscape("何")}, res.Jisho.Kanjis[0].Kanji)
+		require.Equal(t, []server.ApiJishoWordPart{{Text: "何", Reading: "なに"}}, res.Jisho.Word.Parts)
+		require.Equal(t, "what", res.Jisho.Word.Meanings[0].Meaning)
+		require.Equal(t, "Pronoun", res.Jisho.Word.Meanings[0].Tags)
+		require.True(t, res.Jisho.Word.Common)
+		require.Equal(t, 5, res.Jisho.Word.JLPT)
+		require.Equal(t, 5, res.Jisho.Word.WaniKani)
+		require.Equal(t, []string{"Common word", "JLPT N5", "Wanikani level 5"}, res.Jisho.Word.Tags)
+
+		sentence := res.Jisho.Word.Meanings[0].Sentences[0]
+		require.Equal(t, "What did the announcement just say?", sentence.Translation)
+		require.Equal(t, server.ApiJishoWordPart{Text: "今", Reading: "いま"}, sentence.Parts[0])
+		require.Equal(t, server.ApiJishoWordPart{Text: "のアナウンスは"}, sentence.Parts[1])
+
+		require.Equal(t, []string{"Colloquialism", "Usually written using kana alone"}, res.Jisho.Word.Meanings[3].SupplementalInfo)
+		require.Equal(t, []string{"esp. ナニ"}, res.Jisho.Word.Meanings[3].Info)
+		require.Len(t, res.Jisho.Word.Meanings, 8)
+		require.Equal(t, []server.ApiJishoOtherForm{{Word: "ナニ", Link: "/w/" + url.PathEscape("ナニ")}}, res.Jisho.Word.OtherForms)
+		require.Equal(t, []server.ApiLink{{Text: "何か月", Link: "https://jisho.org/search/%E4%BD%95%E3%81%8B%E6%9C%88", SearchLink: "/w/" + url.PathEscape("何か月")}}, res.Jisho.Words[1].Meanings[1].SeeAlso)
+		require.Len(t, res.Kanjidamage, 1)
+		require.Equal(t, "何", res.Kanjidamage[0].Kanji.Kanji)
+		require.Equal(t, "possible", res.Kanjidamage[0].Radicals[1].Meaning)
+		require.Equal(t, []server.ApiKanjidmgKunyomi{{Reading: "なに", Meaning: "what", Usefulness: 5}}, res.Kanjidamage[0].Kunyomi)
+		require.Len(t, res.Kanjidamage[0].Jukugo, 4)
+		require.Equal(t, server.ApiKanjidmgJukugo{Word: "何とか", Reading: "なんとか", Particles: "xxx", Meaning: "something like XXX", Usefulness: 3}, res.Kanjidamage[0].Jukugo[1])
+		require.Equal(t, []server.ApiKanjidmgKanji{{Kanji: "荷", Link: omnikanji.KanjidmgBaseUrl + "/kanji/68-luggage-%E8%8D%B7", SearchLink: "/w/" + url.PathEscape("荷")}}, res.Kanjidamage[0].UsedIn)
+		require.Len(t, res.Kanjidamage[0].Lookalikes, 2)
+		require.Equal(t, "formal visit / question", res.Kanjidamage[0].Lookalikes[1].Meaning)
+		require.Equal(t, `When you say " WHAAT????", you are asking that person if what they just said is really possible.`, res.Kanjidamage[0].Mnemonic)
+		require.Equal(t, server.ApiRichTextRun{Text: "WHAAT", Styles: []string{"translation"}}, res.Kanjidamage[0].MnemonicRich[1])
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		rec := doRequest(http.MethodGet, "?word=noresults")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		var res server.ApiErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Equal(t, http.StatusNotFound, res.Error.Status)
+	})
+
+	t.Run("missing word", func(t *testing.T) {
+		rec := doRequest(http.MethodGet, "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		rec := doRequest(http.MethodPost, "?word=noresults")
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		require.Equal(t, "GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		rec := doRequest(http.MethodOptions, "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("openapi spec parses", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+server.ApiOpenApiPath, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var spec struct {
+			OpenApi string                 `yaml:"openapi"`
+			Paths   map[string]interface{} `yaml:"paths"`
+		}
+		require.NoError(t, yaml.Unmarshal(rec.Body.Bytes(), &spec))
+		require.Equal(t, "3.0.3", spec.OpenApi)
+		require.Contains(t, spec.Paths, "/search")
+	})
+}
+
+func TestSearchStream(t *testing.T) {
+	srv := newTestServer(t, "兄弟")
+
+	t.Run("streams every section", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+server.SearchStreamPath+"?word="+url.QueryEscape("兄弟"), nil)
+		rec := httptest.NewRecorder()
+		srv.HandleSearchStream(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+		var events []string
+		kanjidmgIdxs := map[int]bool{}
+		for _, chunk := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+			lines := strings.SplitN(chunk, "\n", 2)
+			require.Len(t, lines, 2)
+			event := strings.TrimPrefix(lines[0], "event: ")
+			data := strings.TrimPrefix(lines[1], "data: ")
+			events = append(events, event)
+
+			switch event {
+			case "jisho":
+				require.Contains(t, data, `id=\"jisho-section\"`)
+			case "kanjidmg-start":
+				require.JSONEq(t, `{"kanjis": "兄弟"}`, data)
+			case "kanjidmg":
+				var ev struct {
+					Idx  int    `json:"idx"`
+					Html string `json:"html"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(data), &ev))
+				require.Contains(t, ev.Html, `<span class="rich-component">`)
+				kanjidmgIdxs[ev.Idx] = true
+			}
+		}
+
+		require.ElementsMatch(t, []string{"jisho", "kanjidmg-start", "kanjidmg", "kanjidmg", "done"}, events)
+		require.Equal(t, "done", events[len(events)-1])
+		require.Equal(t, map[int]bool{0: true, 1: true}, kanjidmgIdxs)
+	})
+
+	t.Run("missing word", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+server.SearchStreamPath, nil)
+		rec := httptest.NewRecorder()
+		srv.HandleSearchStream(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+// HangingHttpClientMock blocks the requests to urls with the given prefix until the request context is done
+// RecordingHttpClientMock remembers the requested urls
+type RecordingHttpClientMock struct {
+	dictproxy.HttpClient
+	mu   sync.Mutex
+	urls []string
+}
+
+func (c *RecordingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	c.mu.Lock()
+	c.urls = append(c.urls, searchUrl)
+	c.mu.Unlock()
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+func TestJishoPages(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	t.Run("links the next page", func(t *testing.T) {
+		srv := newTestServerWithClient(t, &omnikanji.Config{JishoWordsShown: 5}, NewHttpClientMock(fixtureDir), "何")
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("何"), nil)
+		data := srv.HandleIndex(nil, req)
+
+		require.Len(t, data.TopJishoWords(), 5)
+		require.Len(t, data.MoreJishoWords(), 15)
+		require.Equal(t, "", data.PrevPageUrl())
+		require.Equal(t, "/w/"+url.PathEscape("何")+"?page=2", data.NextPageUrl())
+	})
+
+	t.Run("requests the page from jisho", func(t *testing.T) {
+		httpClient := &RecordingHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir)}
+		srv := newTestServerWithClient(t, &omnikanji.Config{}, httpClient, "何")
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("何")+"?page=2", nil)
+		srv.HandleIndex(nil, req)
+
+		require.Contains(t, httpClient.urls, omnikanji.JishoSearchUrl+url.PathEscape("何")+"%20%23words?page=2")
+	})
+}
+
+type HangingHttpClientMock struct {
+	dictproxy.HttpClient
+	prefix string
+}
+
+func (c *HangingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if strings.HasPrefix(searchUrl, c.prefix) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+func TestSourceTimeout(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	cfg := &omnikanji.Config{
+		JishoTimeout:    50 * time.Millisecond,
+		KanjidmgTimeout: time.Minute,
+	}
+	httpClient := &HangingHttpClientMock{
+		HttpClient: NewHttpClientMock(fixtureDir),
+		prefix:     omnikanji.JishoSearchUrl,
+	}
+	srv := newTestServerWithClient(t, cfg, httpClient, "兄弟")
+
+	t.Run("returns finished sources as partial", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		data := srv.HandleIndex(nil, req)
+
+		require.True(t, data.Partial)
+		require.Nil(t, data.Jisho)
+		require.Equal(t, server.SourceStatusTimeout, data.JishoStatus.Kind)
+		require.Len(t, data.Kanjidmg, 2)
+	})
+
+	t.Run("stops when the client goes away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil).WithContext(ctx)
+		data := srv.HandleIndex(nil, req)
+
+		require.False(t, data.Partial)
+		require.Nil(t, data.Jisho)
+	})
+}
+
+// FailingHttpClientMock responds to requests to urls with the given prefix with the status code
+type FailingHttpClientMock struct {
+	dictproxy.HttpClient
+	prefix     string
+	statusCode int
+}
+
+func (c *FailingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if strings.HasPrefix(searchUrl, c.prefix) {
+		return &http.Response{
+			StatusCode: c.statusCode,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, fmt.Errorf("status code: %d", c.statusCode)
+	}
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+func TestSourceErrors(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	newFailingServer := func(prefix string) testServer {
+		httpClient := &FailingHttpClientMock{
+			HttpClient: NewHttpClientMock(fixtureDir),
+			prefix:     prefix,
+			statusCode: http.StatusServiceUnavailable,
+		}
+		return newTestServerWithClient(t, &omnikanji.Config{}, httpClient, "兄弟")
+	}
+
+	t.Run("failed source is reported next to the other results", func(t *testing.T) {
+		srv := newFailingServer(omnikanji.JishoSearchUrl)
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		data := srv.HandleIndex(nil, req)
+
+		require.Nil(t, data.Jisho)
+		require.Len(t, data.Kanjidmg, 2)
+		require.Nil(t, data.Error)
+		require.Equal(t, &server.SourceStatus{
+			Source:     dictproxy.SourceJisho,
+			Kind:       server.SourceStatusUpstream,
+			Message:    "Jisho returned an error (HTTP 503).",
+			HttpStatus: http.StatusBadGateway,
+		}, data.JishoStatus)
+		require.Equal(t, http.StatusOK, data.HttpStatus())
+
+		rec := httptest.NewRecorder()
+		srv.HandleApiSearch(rec, httptest.NewRequest(http.MethodGet, server.ApiSearchPath+"?word="+url.QueryEscape("兄弟"), nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var res server.ApiSearchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Equal(t, []server.ApiSourceError{{
+			Source:  dictproxy.SourceJisho,
+			Kind:    server.SourceStatusUpstream,
+			Message: "Jisho returned an error (HTTP 503).",
+		}}, res.Errors)
+	})
+
+	t.Run("no results because of failures", func(t *testing.T) {
+		srv := newFailingServer("http")
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		data := srv.HandleIndex(nil, req)
+
+		require.NotNil(t, data.Error)
+		require.NotNil(t, data.JishoStatus)
+		require.Len(t, data.KanjidmgStatuses, 2)
+		require.Equal(t, "兄", data.KanjidmgStatuses[0].Kanji)
+		require.Equal(t, "弟", data.KanjidmgStatuses[1].Kanji)
+		require.Equal(t, http.StatusBadGateway, data.HttpStatus())
+
+		rec := httptest.NewRecorder()
+		srv.HandleApiSearch(rec, httptest.NewRequest(http.MethodGet, server.ApiSearchPath+"?word="+url.QueryEscape("兄弟"), nil))
+		require.Equal(t, http.StatusBadGateway, rec.Code)
+
+		var res server.ApiErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Error.Sources, 3)
+	})
+
+	t.Run("not found is not a failure", func(t *testing.T) {
+		srv := newTestServer(t)
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/w/noresults", nil)
+		data := srv.HandleIndex(nil, req)
+
+		require.Nil(t, data.Error)
+		require.Nil(t, data.JishoStatus)
+		require.Equal(t, http.StatusNotFound, data.HttpStatus())
+	})
+}
+
+// MirrorHttpClientMock serves the requests to the mirror base url as if they were made to the base url
+type MirrorHttpClientMock struct {
+	dictproxy.HttpClient
+	mirrorUrl string
+	baseUrl   string
+}
+
+func (c *MirrorHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if strings.HasPrefix(searchUrl, c.mirrorUrl) {
+		searchUrl = c.baseUrl + strings.TrimPrefix(searchUrl, c.mirrorUrl)
+	}
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+func TestBreaker(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	search := func(srv testServer) *server.TemplateParams {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		return srv.HandleIndex(nil, req)
+	}
+
+	t.Run("skips the failing source for a while", func(t *testing.T) {
+		httpClient := &RecordingHttpClientMock{HttpClient: &FailingHttpClientMock{
+			HttpClient: NewHttpClientMock(fixtureDir),
+			prefix:     omnikanji.KanjidmgBaseUrl,
+			statusCode: http.StatusServiceUnavailable,
+		}}
+		cfg := &omnikanji.Config{BreakerFailures: 2, BreakerCooldown: time.Minute}
+		srv := newTestServerWithClient(t, cfg, httpClient, "兄弟")
+
+		data := search(srv)
+		require.Len(t, data.KanjidmgStatuses, 2)
+		require.Equal(t, server.SourceStatusUpstream, data.KanjidmgStatuses[0].Kind)
+		require.Len(t, httpClient.urls, 3)
+
+		data = search(srv)
+		require.NotNil(t, data.Jisho)
+		require.Equal(t, &server.SourceStatus{
+			Source:     dictproxy.SourceKanjidmg,
+			Kanji:      "兄",
+			Kind:       server.SourceStatusUnavailable,
+			Message:    "兄: Kanjidamage temporarily unavailable.",
+			HttpStatus: http.StatusServiceUnavailable,
+		}, data.KanjidmgStatuses[0])
+		require.Len(t, data.KanjidmgStatuses, 2)
+		require.Len(t, httpClient.urls, 4) // jisho only
+	})
+
+	t.Run("fails over to the mirror", func(t *testing.T) {
+		mirrorUrl := "https://kanjidamage.mirror.example/archive"
+		httpClient := &RecordingHttpClientMock{HttpClient: &FailingHttpClientMock{
+			HttpClient: &MirrorHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir), mirrorUrl: mirrorUrl, baseUrl: omnikanji.KanjidmgBaseUrl},
+			prefix:     omnikanji.KanjidmgBaseUrl,
+			statusCode: http.StatusBadGateway,
+		}}
+		cfg := &omnikanji.Config{BreakerFailures: 1, BreakerCooldown: time.Minute, KanjidmgMirrors: []string{mirrorUrl}}
+		srv := newTestServerWithClient(t, cfg, httpClient, "兄弟")
+
+		data := search(srv)
+		require.Len(t, data.Kanjidmg, 2)
+		require.Empty(t, data.KanjidmgStatuses)
+		require.Contains(t, httpClient.urls, mirrorUrl+"/kanji/"+url.PathEscape("兄"))
+
+		// the base url is skipped once its breaker opened
+		httpClient.urls = nil
+		data = search(srv)
+		require.Len(t, data.Kanjidmg, 2)
+		for _, u := range httpClient.urls {
+			require.False(t, strings.HasPrefix(u, omnikanji.KanjidmgBaseUrl), u)
+		}
+	})
+
+	pageUrl := omnikanji.KanjidmgBaseUrl + "/kanji/" + url.PathEscape("兄")
+	get := func(failover *dictproxy.Failover, ctx context.Context) error {
+		resp, err := failover.Get(ctx, pageUrl)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	cooldown := 10 * time.Millisecond
+
+	t.Run("a cancelled probe is not a success", func(t *testing.T) {
+		httpClient := &CancellingHttpClientMock{HttpClient: &FailingHttpClientMock{
+			HttpClient: NewHttpClientMock(fixtureDir),
+			prefix:     omnikanji.KanjidmgBaseUrl,
+			statusCode: http.StatusServiceUnavailable,
+		}}
+		failover := dictproxy.NewFailover(dictproxy.SourceKanjidmg, httpClient, omnikanji.KanjidmgBaseUrl, nil, 2, cooldown)
+		require.Error(t, get(failover, context.Background()))
+		require.Error(t, get(failover, context.Background()))
+		require.ErrorIs(t, get(failover, context.Background()), dictproxy.ErrUnavailable)
+
+		time.Sleep(2 * cooldown)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, get(failover, ctx), context.Canceled)
+
+		// the next probe fails again, the breaker opens right away
+		err := get(failover, context.Background())
+		require.Error(t, err)
+		require.NotErrorIs(t, err, dictproxy.ErrUnavailable)
+		require.ErrorIs(t, get(failover, context.Background()), dictproxy.ErrUnavailable)
+	})
+
+	t.Run("a probe cancelled after the upstream failed is settled", func(t *testing.T) {
+		httpClient := &CancellingHttpClientMock{HttpClient: &FailingHttpClientMock{
+			HttpClient: NewHttpClientMock(fixtureDir),
+			prefix:     omnikanji.KanjidmgBaseUrl,
+			statusCode: http.StatusServiceUnavailable,
+		}}
+		failover := dictproxy.NewFailover(dictproxy.SourceKanjidmg, httpClient, omnikanji.KanjidmgBaseUrl, nil, 1, cooldown)
+		require.Error(t, get(failover, context.Background()))
+		require.ErrorIs(t, get(failover, context.Background()), dictproxy.ErrUnavailable)
+
+		time.Sleep(2 * cooldown)
+		ctx, cancel := context.WithCancel(context.Background())
+		httpClient.cancel = cancel
+		require.Error(t, get(failover, ctx))
+		httpClient.cancel = nil
+
+		time.Sleep(2 * cooldown)
+		err := get(failover, context.Background())
+		require.Error(t, err)
+		require.NotErrorIs(t, err, dictproxy.ErrUnavailable, "probed again")
+	})
+
+	t.Run("a timeout waiting for a turn is not a failure", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer upstream.Close()
+		failover := dictproxy.NewFailover(dictproxy.SourceKanjidmg, omnihttp.NewClient(omnihttp.WithMaxConcurrent(1)), upstream.URL, nil, 1, time.Minute)
+
+		held, err := failover.Get(context.Background(), upstream.URL+"/held") // keeps the only slot until closed
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = failover.Get(ctx, upstream.URL+"/queued")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		var waitErr *omnihttp.WaitError
+		require.ErrorAs(t, err, &waitErr)
+
+		held.Body.Close()
+		resp, err := failover.Get(context.Background(), upstream.URL+"/after")
+		require.NoError(t, err, "the breaker stayed closed")
+		resp.Body.Close()
+	})
+}
+
+// CancellingHttpClientMock answers the requests whose ctx is already done with its error. Otherwise it calls cancel,
+// if set, after the request, like a client going away once the upstream answered.
+type CancellingHttpClientMock struct {
+	dictproxy.HttpClient
+	cancel context.CancelFunc
+}
+
+func (c *CancellingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	resp, err := c.HttpClient.Get(ctx, searchUrl)
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return resp, err
+}
+
+// KanjidmgListHttpClientMock serves the kanjidamage kanji list page with the given kanjis
+type KanjidmgListHttpClientMock struct {
+	dictproxy.HttpClient
+	kanjis []string
+}
+
+func (c *KanjidmgListHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if searchUrl != omnikanji.KanjidmgListUrl {
+		return c.HttpClient.Get(ctx, searchUrl)
+	}
+	var rows strings.Builder
+	for i, k := range c.kanjis {
+		fmt.Fprintf(&rows, `<tr><td>%d</td><td></td><td><a href="/kanji/%s">%s</a></td></tr>`, i+1, url.PathEscape(k), k)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`<div class="container"><div class="row"><table>` + rows.String() + `</table></div></div>`)),
+	}, nil
+}
+
+func TestKanjidmgConcurrencyCap(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			_, _ = w.Write([]byte("imagebytes"))
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(fixtureDir, "kanjidmg", strings.TrimPrefix(r.URL.Path, "/kanji/")+".html"))
+	}))
+	defer upstream.Close()
+
+	// the page is done with before its images are fetched, they do not wait for its slot
+	httpClient := omnihttp.NewClient(omnihttp.WithMaxConcurrent(1))
+	kanjidmg := dictproxy.NewKanjidmg(upstream.URL, map[string]string{"何": upstream.URL + "/kanji/" + url.PathEscape("何")}, httpClient)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sect, err := kanjidmg.Get(ctx, '何')
+	require.NoError(t, err)
+	require.NotEmpty(t, sect.Radicals)
+}
+
+func TestFurigana(t *testing.T) {
+	// a search page with the word alone, its furigana as jisho renders it
+	page := func(word, furigana string) string {
+		return `<div id="primary"><div class="concept_light"><div class="concept_light-wrapper"><div class="concept_light-readings">` +
+			`<span class="furigana">` + furigana + `</span><span class="text">` + word + `</span>` +
+			`</div></div><div class="meanings-wrapper"></div></div></div>`
+	}
+	pages := map[string]string{
+		"明日":  page("明日", `<ruby class="furigana-justify"><rb>明日</rb><rt>あす</rt></ruby>`),
+		"梅雨":  page("梅雨", `<ruby class="furigana-justify"><rb>梅雨</rb><rt>つゆ</rt></ruby>`),
+		"矢張り": page("矢張り", `<ruby class="furigana-justify"><rb>矢張</rb><rt>やは</rt></ruby><span></span>`),
+		"兄弟":  page("兄弟", `<span class="kanji-2-up kanji">きょう</span><span class="kanji-1-up kanji">だい</span>`),
+		"何処":  page("何処", `<span class="kanji-2-up kanji">どこ</span><span></span>`),
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[strings.TrimPrefix(r.URL.Path, "/search/")])
+	}))
+	defer upstream.Close()
+	jisho := dictproxy.NewJisho(upstream.URL+"/search/", omnihttp.NewClient())
+
+	for word, parts := range map[string][]omnikanji.JishoWordPart{
+		"明日":  {{MainText: "明日", Reading: "あす"}},
+		"梅雨":  {{MainText: "梅雨", Reading: "つゆ"}},
+		"矢張り": {{MainText: "矢張", Reading: "やは"}, {MainText: "り"}},
+		"兄弟":  {{MainText: "兄", Reading: "きょう"}, {MainText: "弟", Reading: "だい"}},
+		"何処":  {{MainText: "何処", Reading: "どこ"}},
+	} {
+		sect, err := jisho.Get(context.Background(), word, 1)
+		require.NoError(t, err)
+		require.Equal(t, parts, sect.FirstWord().Parts, word)
+	}
+}
+
+func TestKanjidmgIndex(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+	httpClient := &KanjidmgListHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir), kanjis: []string{"兄", "弟"}}
+
+	t.Run("serves jisho without the index", func(t *testing.T) {
+		kanjidmg := dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, nil, httpClient)
+		srv, err := server.NewServer(&omnikanji.Config{}, dictproxy.NewJisho(omnikanji.JishoSearchUrl, httpClient), kanjidmg)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil)
+		data := srv.HandleIndex(nil, req)
+		require.NotNil(t, data.Jisho)
+		require.Empty(t, data.Kanjidmg)
+		require.Len(t, data.KanjidmgStatuses, 2)
+		require.Equal(t, server.SourceStatusUnavailable, data.KanjidmgStatuses[0].Kind)
+		require.Equal(t, http.StatusOK, data.HttpStatus())
+
+		// swapped in once loaded
+		require.NoError(t, kanjidmg.RefreshIndex(context.Background()))
+		data = srv.HandleIndex(nil, req)
+		require.Len(t, data.Kanjidmg, 2)
+		require.Empty(t, data.KanjidmgStatuses)
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		kanjidmg := dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, nil, httpClient)
+		require.NoError(t, kanjidmg.RefreshIndex(context.Background()))
+		require.Equal(t, omnikanji.KanjidmgBaseUrl+"/kanji/"+url.PathEscape("兄"), kanjidmg.Url("兄"))
+
+		snapshot := filepath.Join(t.TempDir(), "kanjidmg-index.json")
+		require.NoError(t, kanjidmg.SaveIndexSnapshot(snapshot))
+
+		restarted := dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, nil, &FailingHttpClientMock{prefix: "http", statusCode: http.StatusBadGateway})
+		require.NoError(t, restarted.LoadIndexSnapshot(snapshot))
+		require.Equal(t, kanjidmg.Index().Links, restarted.Index().Links)
+		require.True(t, kanjidmg.Index().Updated.Equal(restarted.Index().Updated))
+
+		// a failed refresh keeps the index
+		require.Error(t, restarted.RefreshIndex(context.Background()))
+		require.Len(t, restarted.Index().Links, 2)
+	})
+
+	t.Run("empty list does not replace the index", func(t *testing.T) {
+		kanjidmg := dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, map[string]string{"兄": "link"}, &KanjidmgListHttpClientMock{HttpClient: httpClient})
+		require.Error(t, kanjidmg.RefreshIndex(context.Background()))
+		require.Equal(t, "link", kanjidmg.Url("兄"))
+	})
+}
+
+func TestAssets(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	getCss := func(srv testServer, name string) (int, string) {
+		rec := httptest.NewRecorder()
+		srv.HandleCss(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080/css/"+name, nil))
+		return rec.Code, rec.Body.String()
+	}
+	builtinCss := func(name string) string {
+		data, err := os.ReadFile(filepath.Join("css", name))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("serves the built-in css", func(t *testing.T) {
+		srv := newTestServerWithClient(t, &omnikanji.Config{}, NewHttpClientMock(fixtureDir))
+		code, body := getCss(srv, "main.css")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, builtinCss("main.css"), body)
+	})
+
+	t.Run("theme overrides the built-in files", func(t *testing.T) {
+		themeDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(themeDir, "css"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(themeDir, "css", "main.css"), []byte("body { color: red; }"), 0644))
+
+		srv := newTestServerWithClient(t, &omnikanji.Config{ThemeDir: themeDir}, NewHttpClientMock(fixtureDir))
+		_, body := getCss(srv, "main.css")
+		require.Equal(t, "body { color: red; }", body)
+		_, body = getCss(srv, "reset.css")
+		require.Equal(t, builtinCss("reset.css"), body)
+
+		require.NoError(t, os.WriteFile(filepath.Join(themeDir, "index.html"), []byte("{{ define }}"), 0644))
+		_, err := server.NewServer(&omnikanji.Config{ThemeDir: themeDir}, nil, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("debug mode reads the assets from disk on every request", func(t *testing.T) {
+		assetsDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(assetsDir, "css"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(assetsDir, "css", "main.css"), []byte("before"), 0644))
+		page, err := os.ReadFile("index.html")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(assetsDir, "index.html"), page, 0644))
+
+		srv := newTestServerWithClient(t, &omnikanji.Config{DebugMode: true, AssetsDir: assetsDir}, NewHttpClientMock(fixtureDir), "兄弟")
+		_, body := getCss(srv, "main.css")
+		require.Equal(t, "before", body)
+
+		require.NoError(t, os.WriteFile(filepath.Join(assetsDir, "css", "main.css"), []byte("after"), 0644))
+		page = bytes.Replace(page, []byte(`{{ define "kanjidmg-entry" }}`), []byte(`{{ define "kanjidmg-entry" }}live-reloaded`), 1)
+		require.NoError(t, os.WriteFile(filepath.Join(assetsDir, "index.html"), page, 0644))
+
+		_, body = getCss(srv, "main.css")
+		require.Equal(t, "after", body)
+		rec := httptest.NewRecorder()
+		srv.HandleSearchStream(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+server.SearchStreamPath+"?word="+url.QueryEscape("兄弟"), nil))
+		require.Contains(t, rec.Body.String(), "live-reloaded")
+	})
+}
+
+func TestRouter(t *testing.T) {
+	srv := newTestServer(t, "兄弟")
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, "http://localhost:8080"+target, nil))
+		return rec
+	}
+
+	t.Run("old search urls redirect to the permalinks", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/search/?page=2&word="+url.QueryEscape(" 兄弟 "))
+		require.Equal(t, http.StatusMovedPermanently, rec.Code)
+		require.Equal(t, "/w/"+url.PathEscape("兄弟")+"?page=2", rec.Header().Get("Location"))
+
+		rec = serve(http.MethodGet, "/search/?word=")
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Equal(t, "/", rec.Header().Get("Location"))
+	})
+
+	t.Run("permalinks redirect to the normalised query", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/w/"+url.PathEscape("Ｄｒｉｖｅｒ\u3000 LICENCE"))
+		require.Equal(t, http.StatusMovedPermanently, rec.Code)
+		require.Equal(t, "/w/driver%20licence", rec.Header().Get("Location"))
+		require.NotContains(t, rec.Body.String(), "<form", "the redirect has no page after it")
+
+		rec = serve(http.MethodGet, "/w/"+url.PathEscape("何？"))
+		require.NotEqual(t, http.StatusMovedPermanently, rec.Code, "the full-width punctuation is kept")
+
+		rec = serve(http.MethodGet, "/k/"+url.PathEscape(" 兄"))
+		require.Equal(t, http.StatusMovedPermanently, rec.Code)
+		require.Equal(t, "/k/"+url.PathEscape("兄"), rec.Header().Get("Location"))
+		require.NotContains(t, rec.Body.String(), "<form")
+
+		rec = serve(http.MethodGet, "/w/"+url.PathEscape(" "))
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Equal(t, "/", rec.Header().Get("Location"))
+		require.NotContains(t, rec.Body.String(), "<form")
+	})
+
+	t.Run("word permalink", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/w/"+url.PathEscape("兄弟"))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `<link rel="canonical" href="/w/`+url.PathEscape("兄弟")+`"/>`)
+	})
+
+	t.Run("kanji page has kanjidamage only", func(t *testing.T) {
+		data := srv.HandleIndex(nil, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.KanjiUrl("兄"), nil))
+		require.Nil(t, data.Jisho)
+		require.Len(t, data.Kanjidmg, 1)
+		require.Equal(t, "兄", *data.Kanjidmg[0].WordSection.Kanji)
+		require.Equal(t, "/k/"+url.PathEscape("兄"), data.CanonicalUrl)
+
+		rec := serve(http.MethodGet, "/k/"+url.PathEscape("兄弟"))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		rec = serve(http.MethodGet, "/k/a")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("unknown paths are not found", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/nothing-here")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		require.Contains(t, rec.Body.String(), "404 Not Found")
+		require.Contains(t, rec.Body.String(), `id="search-form-section"`, "the error page is the styled one")
+	})
+
+	t.Run("other methods are not allowed", func(t *testing.T) {
+		rec := serve(http.MethodPost, "/w/"+url.PathEscape("兄弟"))
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		require.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+		require.Contains(t, rec.Body.String(), "405 Method Not Allowed")
+
+		rec = serve(http.MethodDelete, server.ApiSearchPath+"?word=x")
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		require.Contains(t, rec.Header().Get("Content-Type"), "application/json", "the api answers its methods itself")
+	})
+}
+
+func TestBasePath(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+	srv := newTestServerWithClient(t, &omnikanji.Config{BasePath: "/omnikanji"}, NewHttpClientMock(fixtureDir), "兄弟")
+
+	mux := http.NewServeMux()
+	mux.Handle("/omnikanji/", srv.Handler())
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+target, nil))
+		return rec
+	}
+
+	rec := serve("/omnikanji" + omnikanji.SearchUrl("兄弟"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	require.Contains(t, body, `href="/omnikanji/css/main.css"`)
+	require.Contains(t, body, `<form action="/omnikanji/search/">`)
+	require.Contains(t, body, `href="/omnikanji/k/`+url.PathEscape("兄")+`"`)
+
+	rec = serve("/omnikanji/search/?word=" + url.QueryEscape("兄弟"))
+	require.Equal(t, http.StatusMovedPermanently, rec.Code)
+	require.Equal(t, "/omnikanji"+omnikanji.SearchUrl("兄弟"), rec.Header().Get("Location"))
+
+	rec = serve("/omnikanji/css/main.css")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve("/omnikanji" + server.ApiSearchPath + "?word=" + url.QueryEscape("兄弟"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res server.ApiSearchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, "/omnikanji"+omnikanji.SearchUrl("兄弟"), res.Jisho.Word.SearchLink)
+	require.Equal(t, "/omnikanji"+omnikanji.SearchUrl("兄"), res.Jisho.Kanjis[0].Kanji.SearchLink)
+
+	// the handler stays mounted under the base path, and so do the links
+	srv.SetConfig(&omnikanji.Config{BasePath: "/other"})
+	rec = serve("/omnikanji" + omnikanji.SearchUrl("兄弟"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `href="/omnikanji/css/main.css"`)
+}
+
+// SignallingHttpClientMock tells about every request before making it
+type SignallingHttpClientMock struct {
+	dictproxy.HttpClient
+	requested chan struct{}
+}
+
+func (c *SignallingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	select {
+	case c.requested <- struct{}{}:
+	default:
+	}
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+func TestShutdown(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	// the search hangs at jisho until its timeout, or forever without one
+	newHangingServer := func(jishoTimeout time.Duration) (testServer, chan struct{}) {
+		requested := make(chan struct{}, 1)
+		httpClient := &SignallingHttpClientMock{
+			HttpClient: &HangingHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir), prefix: omnikanji.JishoSearchUrl},
+			requested:  requested,
+		}
+		return newTestServerWithClient(t, &omnikanji.Config{JishoTimeout: jishoTimeout}, httpClient, "兄弟"), requested
+	}
+	search := func(srv testServer) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil))
+			done <- rec
+		}()
+		return done
+	}
+
+	t.Run("waits for the lookups in progress", func(t *testing.T) {
+		srv, requested := newHangingServer(100 * time.Millisecond)
+		done := search(srv)
+		<-requested
+
+		require.NoError(t, srv.Shutdown(context.Background()))
+		select {
+		case rec := <-done:
+			require.Equal(t, http.StatusOK, rec.Code, "the kanjidamage results are shown")
+		case <-time.After(time.Second):
+			t.Fatal("the search did not finish")
+		}
+	})
+
+	t.Run("cancels the lookups once out of time", func(t *testing.T) {
+		srv, requested := newHangingServer(0)
+		done := search(srv)
+		<-requested
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the search was not cancelled")
+		}
+	})
+
+	t.Run("cancels the lookups started after it", func(t *testing.T) {
+		srv, _ := newHangingServer(0)
+		require.NoError(t, srv.Shutdown(context.Background()))
+
+		select {
+		case <-search(srv): // e.g. through the handler mounted by an embedding service
+		case <-time.After(time.Second):
+			t.Fatal("the search was not cancelled")
+		}
+	})
+}
+
+// PanickingHttpClientMock panics on requests to urls with the given prefix, like a parser bug would
+type PanickingHttpClientMock struct {
+	dictproxy.HttpClient
+	prefix string
+}
+
+func (c *PanickingHttpClientMock) Get(ctx context.Context, searchUrl string) (*http.Response, error) {
+	if strings.HasPrefix(searchUrl, c.prefix) {
+		panic("parser bug")
+	}
+	return c.HttpClient.Get(ctx, searchUrl)
+}
+
+// PanickingJishoMock panics building the jisho link of the searched english word, which is done by the request handler itself
+type PanickingJishoMock struct {
+	server.JishoSectionGetter
+	word string
+}
+
+func (j *PanickingJishoMock) Url(word string) string {
+	if word == j.word {
+		panic("handler bug")
+	}
+	return j.JishoSectionGetter.Url(word)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	t.Run("a panicking source is an error of the source", func(t *testing.T) {
+		httpClient := &PanickingHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir), prefix: omnikanji.KanjidmgBaseUrl + "/kanji/" + url.PathEscape("弟")}
+		srv := newTestServerWithClient(t, &omnikanji.Config{}, httpClient, "兄弟")
+
+		data := srv.HandleIndex(nil, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("兄弟"), nil))
+		require.NotNil(t, data.Jisho)
+		require.Len(t, data.Kanjidmg, 1)
+		require.Len(t, data.KanjidmgStatuses, 1)
+		require.Equal(t, server.SourceStatusError, data.KanjidmgStatuses[0].Kind)
+		require.Regexp(t, `^弟: Something went wrong with Kanjidamage \(error [0-9a-f]{12}\)\.$`, data.KanjidmgStatuses[0].Message)
+	})
+
+	t.Run("a panicking english lookup is an error of jisho", func(t *testing.T) {
+		httpClient := &PanickingHttpClientMock{HttpClient: NewHttpClientMock(fixtureDir), prefix: omnikanji.JishoSearchUrl}
+		srv := newTestServerWithClient(t, &omnikanji.Config{}, httpClient)
+
+		data := srv.HandleIndex(nil, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("driver's licence"), nil))
+		require.Nil(t, data.Jisho)
+		require.Equal(t, server.SourceStatusError, data.JishoStatus.Kind)
+		require.Equal(t, http.StatusInternalServerError, data.HttpStatus())
+	})
+
+	t.Run("a panicking request gets the error page with a report form", func(t *testing.T) {
+		httpClient := NewHttpClientMock(fixtureDir)
+		jisho := &PanickingJishoMock{JishoSectionGetter: dictproxy.NewJisho(omnikanji.JishoSearchUrl, httpClient), word: "driver's licence"}
+		srv, err := server.NewServer(&omnikanji.Config{}, jisho, dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, nil, httpClient))
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+omnikanji.SearchUrl("driver's licence"), nil))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Regexp(t, `The problem was logged as error <code>[0-9a-f]{12}</code>`, rec.Body.String())
+		require.Contains(t, rec.Body.String(), `<form method="post" action="/report/">`)
+		require.NotContains(t, rec.Body.String(), "handler bug", "the details are shown in debug mode only")
+	})
+
+	t.Run("report", func(t *testing.T) {
+		srv := newTestServer(t)
+		form := url.Values{"id": {"0123456789ab"}, "message": {"looking up 兄弟"}}
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+server.ReportPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "Thank you for the report")
+	})
+
+	t.Run("reports of other sites are refused and the others limited", func(t *testing.T) {
+		srv := newTestServer(t)
+		report := func(header http.Header) int {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+server.ReportPath, strings.NewReader("id=0123456789ab&message=spam"))
+			req.Header = header
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		require.Equal(t, http.StatusForbidden, report(http.Header{"Sec-Fetch-Site": {"cross-site"}}))
+		require.Equal(t, http.StatusForbidden, report(http.Header{"Origin": {"https://example.com"}}))
+		require.Equal(t, http.StatusOK, report(http.Header{"Sec-Fetch-Site": {"same-origin"}}))
+		require.Equal(t, http.StatusOK, report(http.Header{"Origin": {"http://localhost:8080"}}))
+
+		codes := map[int]int{}
+		for i := 0; i < 10; i++ {
+			codes[report(http.Header{})]++
+		}
+		require.Equal(t, map[int]int{http.StatusOK: 8, http.StatusTooManyRequests: 2}, codes, "the burst is 10")
+	})
+}
+
+type testServer interface {
+	HandleIndex(w http.ResponseWriter, r *http.Request) *server.TemplateParams
+	HandleApiSearch(w http.ResponseWriter, r *http.Request)
+	HandleSearchStream(w http.ResponseWriter, r *http.Request)
+	HandleCss(w http.ResponseWriter, r *http.Request)
+	Handler() http.Handler
+	Shutdown(ctx context.Context) error
+	SetConfig(cfg *omnikanji.Config)
+}
+
+// newTestServer creates a server backed by the fixture directory. Kanjidamage links are generated
+// for the kanjis of the given words.
+func newTestServer(t *testing.T, words ...string) testServer {
+	fixtureDir, err := filepath.Abs("fixture")
+	require.NoError(t, err)
+
+	return newTestServerWithClient(t, &omnikanji.Config{}, NewHttpClientMock(fixtureDir), words...)
+}
+
+func newTestServerWithClient(t *testing.T, cfg *omnikanji.Config, httpClient dictproxy.HttpClient, words ...string) testServer {
+	// "special case" (fill by hand) for creating kanjidmg lookup urls (when looked up words do not contain the kanjis, but jisho returns kanjis)
+	kanjidmgLinkWords := []string{
+		"運転免許",
+		"矢張",
+	}
+	kanjidmgLinkWords = append(kanjidmgLinkWords, words...)
+
+	kanjidmgLinks := make(map[string]string)
+	for _, word := range kanjidmgLinkWords {
+		for _, r := range word {
+			if !jptext.IsKanji(r) {
+				continue
+			}
+
+			rStr := string(r)
+			kanjidmgLinks[rStr] = omnikanji.KanjidmgBaseUrl + "/kanji/" + url.PathEscape(rStr)
+		}
+	}
+
+	jishoClient := dictproxy.NewFailover(dictproxy.SourceJisho, httpClient, omnikanji.JishoBaseUrl,
+		cfg.JishoMirrors, cfg.BreakerFailures, cfg.BreakerCooldown)
+	kanjidmgClient := dictproxy.NewFailover(dictproxy.SourceKanjidmg, httpClient, omnikanji.KanjidmgBaseUrl,
+		cfg.KanjidmgMirrors, cfg.BreakerFailures, cfg.BreakerCooldown)
+
+	var jisho server.JishoSectionGetter = dictproxy.NewJisho(omnikanji.JishoSearchUrl, jishoClient)
+	var kanjidmg server.KanjidmgSectionGetter = dictproxy.NewKanjidmg(omnikanji.KanjidmgBaseUrl, kanjidmgLinks, kanjidmgClient)
+	if cfg.CacheSize > 0 {
+		store, err := cache.NewStore(cfg)
+		require.NoError(t, err)
+		jisho = cache.NewJisho(jisho, store, cfg.CacheTTL, dictproxy.ParserVersion, cfg.JishoTimeout)
+		kanjidmg = cache.NewKanjidmg(kanjidmg, store, cfg.CacheTTL, dictproxy.ParserVersion, cfg.KanjidmgTimeout)
+	}
+	srv, err := server.NewServer(cfg, jisho, kanjidmg)
+	require.NoError(t, err)
+	return srv
+}
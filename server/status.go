@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/zemiret/omnikanji/dictproxy"
+)
+
+const (
+	SourceStatusUpstream    = "upstream"
+	SourceStatusTimeout     = "timeout"
+	SourceStatusUnavailable = "unavailable"
+	SourceStatusParse       = "parse"
+	SourceStatusError       = "error"
+)
+
+// SourceStatus explains why a source has no results, when it failed.
+type SourceStatus struct {
+	Source     string
+	Kanji      string // Set for kanjidamage lookups
+	Kind       string
+	Message    string
+	HttpStatus int
+}
+
+// newSourceStatus maps an error of a dictproxy source to what is shown to the user.
+func newSourceStatus(source string, kanji string, err error) *SourceStatus {
+	status := &SourceStatus{
+		Source: source,
+		Kanji:  kanji,
+	}
+
+	var upstreamErr *dictproxy.UpstreamError
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &upstreamErr):
+		status.Kind = SourceStatusUpstream
+		status.HttpStatus = http.StatusBadGateway
+		if upstreamErr.StatusCode != 0 {
+			status.Message = fmt.Sprintf("%s returned an error (HTTP %d).", source, upstreamErr.StatusCode)
+		} else {
+			status.Message = fmt.Sprintf("Could not connect to %s.", source)
+		}
+	case errors.Is(err, dictproxy.ErrTimeout):
+		status.Kind = SourceStatusTimeout
+		status.HttpStatus = http.StatusGatewayTimeout
+		status.Message = fmt.Sprintf("%s took too long to answer.", source)
+	case errors.Is(err, dictproxy.ErrUnavailable):
+		status.Kind = SourceStatusUnavailable
+		status.HttpStatus = http.StatusServiceUnavailable
+		status.Message = fmt.Sprintf("%s temporarily unavailable.", source)
+	case errors.As(err, &panicErr):
+		status.Kind = SourceStatusError
+		status.HttpStatus = http.StatusInternalServerError
+		status.Message = fmt.Sprintf("Something went wrong with %s (error %s).", source, panicErr.Id)
+	case errors.Is(err, dictproxy.ErrParse):
+		status.Kind = SourceStatusParse
+		status.HttpStatus = http.StatusInternalServerError
+		status.Message = fmt.Sprintf("Could not read the %s page.", source)
+	default:
+		status.Kind = SourceStatusError
+		status.HttpStatus = http.StatusInternalServerError
+		status.Message = fmt.Sprintf("Something went wrong with %s.", source)
+	}
+
+	if kanji != "" {
+		status.Message = kanji + ": " + status.Message
+	}
+
+	return status
+}
+
+// HttpStatus is the status code of the response rendering the params.
+// Results of any source make the response successful, even if other sources failed.
+func (p *TemplateParams) HttpStatus() int {
+	if p != nil && p.ErrorStatus != 0 {
+		return p.ErrorStatus
+	}
+	if p == nil || p.Notice != "" || p.Stream != nil || p.Jisho != nil || len(p.Kanjidmg) > 0 {
+		return http.StatusOK
+	}
+	if p.JishoStatus != nil {
+		return p.JishoStatus.HttpStatus
+	}
+	if len(p.KanjidmgStatuses) > 0 {
+		return p.KanjidmgStatuses[0].HttpStatus
+	}
+	return http.StatusNotFound
+}
+
+// ErrorStatusText is the name of the ErrorStatus, e.g. Not Found
+func (p *TemplateParams) ErrorStatusText() string {
+	return http.StatusText(p.ErrorStatus)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/zemiret/omnikanji"
+	"github.com/zemiret/omnikanji/jptext"
+)
+
+const SearchStreamPath = "/stream/"
+
+// StreamParams is set on TemplateParams when the page is rendered without results, which are then
+// pushed to the page by the stream endpoint as soon as each dictionary answers.
+type StreamParams struct {
+	Url           string
+	FullRenderUrl string
+	Kanjis        []string
+}
+
+type streamJishoEvent struct {
+	Html string `json:"html"`
+}
+
+type streamKanjidmgStartEvent struct {
+	Kanjis string `json:"kanjis"`
+}
+
+type streamKanjidmgEvent struct {
+	Idx  int    `json:"idx"`
+	Html string `json:"html"`
+}
+
+// isStreaming tells whether the results page should be streamed. The "stream" query parameter
+// overrides the configured default.
+func (s *server) isStreaming(r *http.Request) bool {
+	switch r.URL.Query().Get("stream") {
+	case "0":
+		return false
+	case "1":
+		return true
+	}
+	return s.config().Streaming
+}
+
+func (s *server) streamParams(word string, page int) *TemplateParams {
+	query := url.Values{omnikanji.QuerySearchKey: {word}}
+	if page > 1 {
+		query.Set(omnikanji.QueryPageKey, strconv.Itoa(page))
+	}
+
+	fullRenderQuery := url.Values{"stream": {"0"}}
+	if page > 1 {
+		fullRenderQuery.Set(omnikanji.QueryPageKey, strconv.Itoa(page))
+	}
+
+	var kanjis []string
+	if jptext.IsJapaneseWord(word) {
+		for _, k := range jptext.ExtractKanjis(word) {
+			kanjis = append(kanjis, string(k))
+		}
+	}
+
+	tParams := s.newTemplateParams(word)
+	tParams.Stream = &StreamParams{
+		Url:           SearchStreamPath + "?" + query.Encode(),
+		FullRenderUrl: omnikanji.SearchUrl(word) + "?" + fullRenderQuery.Encode(),
+		Kanjis:        kanjis,
+	}
+	return tParams
+}
+
+// HandleSearchStream sends the results of a search as Server-Sent Events. Every event carries
+// an html fragment rendered from the index template, followed by a final "done" event.
+func (s *server) HandleSearchStream(w http.ResponseWriter, r *http.Request) {
+	word := r.URL.Query().Get(omnikanji.QuerySearchKey)
+	if word == "" {
+		http.Error(w, "missing query parameter: "+omnikanji.QuerySearchKey, http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // do not let nginx buffer the stream
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Stops the lookup when the client goes away or a write fails
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	for res := range s.lookup(ctx, word, parsePage(r)) {
+		if err := s.streamResult(w, word, res); err != nil {
+			s.Errorf(err, "error streaming search result")
+			return
+		}
+		flusher.Flush()
+	}
+
+	if err := writeEvent(w, "done", struct{}{}); err != nil {
+		s.Errorf(err, "error streaming search result")
+	}
+	flusher.Flush()
+}
+
+func (s *server) streamResult(w http.ResponseWriter, word string, res lookupResult) error {
+	switch res.kind {
+	case lookupResultJisho:
+		tParams := s.newTemplateParams(word)
+		tParams.Jisho = res.jisho
+		if !jptext.IsJapaneseWord(word) {
+			tParams.EnglishSearchedWord = word
+			tParams.JishoEnglishWordLink = s.jisho.Url(word)
+		}
+
+		html, err := s.renderFragment("jisho-section", tParams)
+		if err != nil {
+			return err
+		}
+		return writeEvent(w, "jisho", streamJishoEvent{Html: html})
+	case lookupResultKanjidmgStart:
+		return writeEvent(w, "kanjidmg-start", streamKanjidmgStartEvent{Kanjis: res.kanjis})
+	case lookupResultKanjidmg:
+		html, err := s.renderFragment("kanjidmg-entry", res.kanjidmg)
+		if err != nil {
+			return err
+		}
+		return writeEvent(w, "kanjidmg", streamKanjidmgEvent{Idx: res.kanjidmgIdx, Html: html})
+	case lookupResultJishoError:
+		html, err := s.renderFragment("jisho-status", res.status)
+		if err != nil {
+			return err
+		}
+		if err := s.writeStatusPartial(w, res.status); err != nil {
+			return err
+		}
+		return writeEvent(w, "jisho", streamJishoEvent{Html: html})
+	case lookupResultKanjidmgError:
+		html, err := s.renderFragment("source-status", res.status)
+		if err != nil {
+			return err
+		}
+		if err := s.writeStatusPartial(w, res.status); err != nil {
+			return err
+		}
+		return writeEvent(w, "kanjidmg", streamKanjidmgEvent{Idx: res.kanjidmgIdx, Html: html})
+	}
+
+	return nil
+}
+
+// writeStatusPartial lets the page know the results are partial, if the source failed because of a timeout.
+func (s *server) writeStatusPartial(w http.ResponseWriter, status *SourceStatus) error {
+	if status.Kind != SourceStatusTimeout {
+		return nil
+	}
+	return writeEvent(w, "partial", struct{}{})
+}
+
+func (s *server) renderFragment(name string, data interface{}) (string, error) {
+	tpl, err := s.templates()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}